@@ -0,0 +1,156 @@
+package common
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxLoggedPeers bounds the set of rejected peers remembered for logging.
+const maxLoggedPeers = 1000
+
+// ACLConfig represents the IP access lists of a listener
+type ACLConfig struct {
+	Allow []string `yaml:"allow,omitempty"`
+	Deny  []string `yaml:"deny,omitempty"`
+	// TrustedProxies are the webhook peers whose X-Forwarded-For and
+	// X-Real-IP headers are used to find the client address
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+}
+
+// ACL checks peer addresses against CIDR allow and deny lists. Deny entries
+// take precedence; when allow entries exist, only matching peers pass.
+type ACL struct {
+	name    string
+	log     *slog.Logger
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+	trusted []*net.IPNet
+
+	mu     sync.Mutex
+	logged map[string]struct{}
+}
+
+// NewACL creates the access lists described by cfg. name identifies the
+// listener in logs and metrics.
+func NewACL(name string, cfg *ACLConfig) (*ACL, error) {
+	a := &ACL{
+		name:   metricKey(name),
+		log:    ComponentLogger("acl", "listen", name),
+		logged: make(map[string]struct{}),
+	}
+	for _, l := range []struct {
+		entries []string
+		dst     *[]*net.IPNet
+	}{
+		{cfg.Allow, &a.allow},
+		{cfg.Deny, &a.deny},
+		{cfg.TrustedProxies, &a.trusted},
+	} {
+		nets, err := parseCIDRs(l.entries)
+		if err != nil {
+			return nil, err
+		}
+		*l.dst = nets
+	}
+	return a, nil
+}
+
+// parseCIDRs parses CIDR blocks, accepting plain addresses as single hosts.
+func parseCIDRs(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR: %s", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether the peer at addr (an IP or host:port) may send
+// events. Rejected peers are counted, and logged once. A nil ACL allows
+// everything.
+func (a *ACL) Allowed(addr string) bool {
+	if a == nil {
+		return true
+	}
+	host := clientIP(addr)
+	ip := net.ParseIP(host)
+	if ip != nil && !containsIP(a.deny, ip) && (len(a.allow) == 0 || containsIP(a.allow, ip)) {
+		return true
+	}
+
+	AddMetric("acl."+a.name+".rejected", 1)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.logged[host]; !ok && len(a.logged) < maxLoggedPeers {
+		a.logged[host] = struct{}{}
+		a.log.Warn("Rejected peer", "peer", host)
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that sent req. When the direct
+// peer is a trusted proxy, X-Forwarded-For is walked from the right and the
+// first untrusted address is used, falling back to X-Real-IP. A hop that is
+// not an IP address ends the walk at the last trusted address, as nothing
+// left of it can be relied on. A nil ACL returns the direct peer.
+func (a *ACL) ClientIP(req *http.Request) string {
+	peer := clientIP(req.RemoteAddr)
+	if a == nil || len(a.trusted) == 0 || !a.trustedIP(peer) {
+		return peer
+	}
+
+	if forwarded := req.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			if net.ParseIP(hop) == nil {
+				return peer
+			}
+			if !a.trustedIP(hop) {
+				return hop
+			}
+			peer = hop
+		}
+		return peer
+	}
+	if realIP := strings.TrimSpace(req.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return peer
+}
+
+func (a *ACL) trustedIP(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && containsIP(a.trusted, ip)
+}
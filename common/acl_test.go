@@ -0,0 +1,78 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestACLClientIP(t *testing.T) {
+	acl, err := NewACL("http://127.0.0.1:0", &ACLConfig{TrustedProxies: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		acl    *ACL
+		peer   string
+		header http.Header
+		want   string
+	}{
+		{"no ACL", nil, "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"192.0.2.1"}}, "10.0.0.1"},
+		{"untrusted peer", acl, "198.51.100.7:1234", http.Header{"X-Forwarded-For": {"192.0.2.1"}}, "198.51.100.7"},
+		{"trusted peer without headers", acl, "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"single hop", acl, "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"192.0.2.1"}}, "192.0.2.1"},
+		{
+			"spoofed leftmost hop",
+			acl, "10.0.0.1:1234",
+			http.Header{"X-Forwarded-For": {"203.0.113.9, 192.0.2.1, 10.0.0.2"}},
+			"192.0.2.1",
+		},
+		{
+			"hops in several headers",
+			acl, "10.0.0.1:1234",
+			http.Header{"X-Forwarded-For": {"203.0.113.9", "192.0.2.1,10.0.0.2"}},
+			"192.0.2.1",
+		},
+		{"only trusted hops", acl, "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"malformed hop", acl, "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"192.0.2.1, bogus, 10.0.0.2"}}, "10.0.0.2"},
+		{"malformed only hop", acl, "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"not an ip"}}, "10.0.0.1"},
+		{"empty hops", acl, "10.0.0.1:1234", http.Header{"X-Forwarded-For": {" , 192.0.2.1 ,"}}, "192.0.2.1"},
+		{"real ip", acl, "10.0.0.1:1234", http.Header{"X-Real-Ip": {"192.0.2.1"}}, "192.0.2.1"},
+		{"malformed real ip", acl, "10.0.0.1:1234", http.Header{"X-Real-Ip": {"192.0.2.1:80"}}, "10.0.0.1"},
+		{"real ip from untrusted peer", acl, "198.51.100.7:1234", http.Header{"X-Real-Ip": {"192.0.2.1"}}, "198.51.100.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.RemoteAddr = tt.peer
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			assert.Equal(t, tt.want, tt.acl.ClientIP(req))
+		})
+	}
+}
+
+func TestACLAllowed(t *testing.T) {
+	acl, err := NewACL("127.0.0.1:0", &ACLConfig{Allow: []string{"192.0.2.0/24", "198.51.100.7"}, Deny: []string{"192.0.2.13"}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"192.0.2.1:514", true},
+		{"198.51.100.7", true},
+		{"192.0.2.13:514", false},
+		{"203.0.113.1:514", false},
+		{"bogus", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, acl.Allowed(tt.addr), tt.addr)
+	}
+	assert.True(t, (*ACL)(nil).Allowed("bogus"))
+}
@@ -0,0 +1,81 @@
+package common
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"net"
+	"net/http"
+)
+
+// AdminServer exposes operational endpoints such as metrics.
+type AdminServer struct {
+	listen string
+	token  string
+	mux    *http.ServeMux
+	server *http.Server
+}
+
+func NewAdmin(listen string) *AdminServer {
+	a := &AdminServer{
+		listen: listen,
+		mux:    http.NewServeMux(),
+	}
+	a.mux.Handle("/debug/vars", expvar.Handler())
+
+	a.server = &http.Server{
+		Addr:    listen,
+		Handler: a.mux,
+	}
+	return a
+}
+
+// SetToken sets the token required, as bearer token or X-Api-Key header, by
+// requests changing state. Without a token only loopback clients may change
+// state.
+func (a *AdminServer) SetToken(token string) {
+	a.token = token
+}
+
+// Handle registers an additional admin endpoint.
+func (a *AdminServer) Handle(pattern string, handler http.Handler) {
+	a.mux.Handle(pattern, handler)
+}
+
+// HandleProtected registers an admin endpoint whose requests other than GET
+// and HEAD must be authorized.
+func (a *AdminServer) HandleProtected(pattern string, handler http.Handler) {
+	a.mux.Handle(pattern, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead && !a.authorized(req) {
+			http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(rw, req)
+	}))
+}
+
+func (a *AdminServer) authorized(req *http.Request) bool {
+	if a.token != "" {
+		return subtle.ConstantTimeCompare([]byte(authToken(req)), []byte(a.token)) == 1
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (a *AdminServer) Run() error {
+	if err := a.server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (a *AdminServer) Stop() error {
+	return a.server.Close()
+}
+
+func (a *AdminServer) ListenAddr() string {
+	return a.listen
+}
@@ -0,0 +1,47 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminLogLevelAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		method     string
+		remoteAddr string
+		header     http.Header
+		want       int
+	}{
+		{"read from anywhere", "", http.MethodGet, "192.0.2.1:1234", nil, http.StatusOK},
+		{"change from loopback", "", http.MethodPut, "127.0.0.1:1234", nil, http.StatusOK},
+		{"change from ipv6 loopback", "", http.MethodPut, "[::1]:1234", nil, http.StatusOK},
+		{"change from remote", "", http.MethodPut, "192.0.2.1:1234", nil, http.StatusUnauthorized},
+		{"change with bearer token", "secret", http.MethodPut, "192.0.2.1:1234", http.Header{"Authorization": {"Bearer secret"}}, http.StatusOK},
+		{"change with api key", "secret", http.MethodPost, "192.0.2.1:1234", http.Header{"X-Api-Key": {"secret"}}, http.StatusOK},
+		{"change with wrong token", "secret", http.MethodPut, "192.0.2.1:1234", http.Header{"Authorization": {"Bearer nope"}}, http.StatusUnauthorized},
+		{"token required on loopback", "secret", http.MethodPut, "127.0.0.1:1234", nil, http.StatusUnauthorized},
+	}
+
+	defer SetLogLevel("info")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAdmin("127.0.0.1:0")
+			a.SetToken(tt.token)
+			a.HandleProtected("/log/level", LogLevelHandler())
+
+			req := httptest.NewRequest(tt.method, "/log/level?level=info", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			rec := httptest.NewRecorder()
+			a.server.Handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	defaultDedupSize = 100000
+	defaultDedupTTL  = time.Minute
+)
+
+// sortedJSON encodes maps with sorted keys so equal events hash equally
+var sortedJSON = sonic.Config{SortMapKeys: true}.Froze()
+
+// dedupIgnoredFields describe how a syslog message was delivered rather
+// than what it says, so that a copy retried from another port or relayed
+// twice hashes like the original.
+var dedupIgnoredFields = []string{"client", "tls_peer", "received_at", "raw"}
+
+// isSyslogEvent reports whether data has the schema of syslog messages.
+func isSyslogEvent(data map[string]interface{}) bool {
+	if _, ok := data["received_at"].(string); !ok {
+		return false
+	}
+	switch data["format"] {
+	case "RFC3164", "RFC5424", "RFC6587":
+		return true
+	}
+	return false
+}
+
+// dedupContent returns data without the fields that differ between copies
+// of a syslog message. A timestamp equal to received_at was synthesized on
+// reception and is left out as well. Other events are hashed as they are,
+// as their client or raw fields are part of the payload.
+func dedupContent(data map[string]interface{}) map[string]interface{} {
+	if !isSyslogEvent(data) {
+		return data
+	}
+	content := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		content[k] = v
+	}
+	if receivedAt, ok := content["received_at"]; ok && content["timestamp"] == receivedAt {
+		delete(content, "timestamp")
+	}
+	for _, k := range dedupIgnoredFields {
+		delete(content, k)
+	}
+	return content
+}
+
+type dedupEntry struct {
+	key     uint64
+	expires time.Time
+}
+
+// dedupCache remembers keys for ttl, holding at most size keys by evicting
+// the oldest ones first.
+type dedupCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	keys  map[uint64]time.Time
+	queue []dedupEntry
+}
+
+// Seen records key and reports whether it was already present and not
+// expired.
+func (c *dedupCache) Seen(key uint64) bool {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.queue) > 0 && (now.After(c.queue[0].expires) || len(c.queue) >= c.size) {
+		c.evictOldest()
+	}
+	if expires, ok := c.keys[key]; ok && now.Before(expires) {
+		return true
+	}
+
+	expires := now.Add(c.ttl)
+	c.keys[key] = expires
+	c.queue = append(c.queue, dedupEntry{key: key, expires: expires})
+	return false
+}
+
+func (c *dedupCache) evictOldest() {
+	e := c.queue[0]
+	c.queue = c.queue[1:]
+	// The key may have been re-added after this entry expired
+	if c.keys[e.key] == e.expires {
+		delete(c.keys, e.key)
+	}
+}
+
+// dedupProcessor drops events whose key was seen within the ttl. The key is
+// built from field/fields, or from the event content when none are
+// configured. Events lacking one of the fields are passed on.
+func dedupProcessor(cfg ProcessorConfig) (predicate, error) {
+	if cfg.Size < 0 {
+		return nil, fmt.Errorf("size must not be negative")
+	}
+	cache := &dedupCache{
+		size: cfg.Size,
+		ttl:  cfg.TTL,
+		keys: make(map[uint64]time.Time),
+	}
+	if cache.size == 0 {
+		cache.size = defaultDedupSize
+	}
+	if cache.ttl <= 0 {
+		cache.ttl = defaultDedupTTL
+	}
+
+	var paths [][]string
+	if cfg.Field != "" || len(cfg.Fields) > 0 {
+		paths, _ = fieldPaths(cfg)
+	}
+
+	return func(data map[string]interface{}) bool {
+		h := xxhash.New()
+		if paths == nil {
+			raw, err := sortedJSON.Marshal(dedupContent(data))
+			if err != nil {
+				return true
+			}
+			h.Write(raw)
+		} else {
+			for _, path := range paths {
+				value, ok := GetCheckData(data, path)
+				if !ok {
+					return true
+				}
+				h.WriteString(value)
+				h.Write([]byte{0})
+			}
+		}
+
+		if cache.Seen(h.Sum64()) {
+			AddMetric("dedup.dropped", 1)
+			return false
+		}
+		return true
+	}, nil
+}
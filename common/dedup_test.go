@@ -0,0 +1,126 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+func TestDedupProcessor(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    ProcessorConfig
+		events []map[string]interface{}
+		kept   int
+	}{
+		{
+			name: "whole event",
+			events: []map[string]interface{}{
+				{"id": "1", "msg": "a"},
+				{"msg": "a", "id": "1"},
+				{"id": "2", "msg": "a"},
+			},
+			kept: 2,
+		},
+		{
+			name: "syslog delivery fields ignored",
+			events: []map[string]interface{}{
+				{"format": "RFC5424", "msg": "a", "client": "192.0.2.1:1000", "received_at": "2024-05-01T12:00:00.1Z", "timestamp": "2024-05-01T12:00:00.1Z"},
+				{"format": "RFC5424", "msg": "a", "client": "192.0.2.1:1001", "received_at": "2024-05-01T12:00:00.2Z", "timestamp": "2024-05-01T12:00:00.2Z"},
+				{"format": "RFC5424", "msg": "a", "client": "192.0.2.2:1000", "tls_peer": "relay-2", "received_at": "2024-05-01T12:00:00.3Z", "raw": map[string]interface{}{"client": "192.0.2.2:1000"}},
+			},
+			kept: 1,
+		},
+		{
+			name: "sender timestamps kept",
+			events: []map[string]interface{}{
+				{"format": "RFC3164", "msg": "a", "received_at": "2024-05-01T12:00:00.1Z", "timestamp": "2024-05-01T11:00:00Z"},
+				{"format": "RFC3164", "msg": "a", "received_at": "2024-05-01T12:00:00.2Z", "timestamp": "2024-05-01T11:00:01Z"},
+			},
+			kept: 2,
+		},
+		{
+			name: "webhook client field kept",
+			events: []map[string]interface{}{
+				{"msg": "a", "client": "acme"},
+				{"msg": "a", "client": "globex"},
+				{"msg": "a", "raw": "x", "received_at": "2024-05-01T12:00:00.1Z"},
+				{"msg": "a", "raw": "y", "received_at": "2024-05-01T12:00:00.1Z"},
+			},
+			kept: 4,
+		},
+		{
+			name: "missing key field passed on",
+			cfg:  ProcessorConfig{Field: "id"},
+			events: []map[string]interface{}{
+				{"msg": "a"}, {"msg": "b"}, {"msg": "c"}, {"id": "", "msg": "d"}, {"id": "", "msg": "e"},
+			},
+			kept: 4,
+		},
+		{
+			name: "one missing key field passed on",
+			cfg:  ProcessorConfig{Fields: []string{"id", "user.name"}},
+			events: []map[string]interface{}{
+				{"id": "1"}, {"id": "1"}, {"id": "1", "user": map[string]interface{}{}},
+			},
+			kept: 3,
+		},
+		{
+			name: "fields",
+			cfg:  ProcessorConfig{Fields: []string{"id", "user.name"}},
+			events: []map[string]interface{}{
+				{"id": "1", "user": map[string]interface{}{"name": "alice"}, "n": 1},
+				{"id": "1", "user": map[string]interface{}{"name": "alice"}, "n": 2},
+				{"id": "1", "user": map[string]interface{}{"name": "bob"}},
+			},
+			kept: 2,
+		},
+		{
+			name: "size evicts oldest",
+			cfg:  ProcessorConfig{Field: "id", Size: 1},
+			events: []map[string]interface{}{
+				{"id": "1"}, {"id": "2"}, {"id": "1"},
+			},
+			kept: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Type = "dedup"
+			processor, err := NewProcessor(tt.cfg)
+			require.NoError(t, err)
+			kept := 0
+			for _, data := range tt.events {
+				events, err := processor.Process(NewEventFromMap(data))
+				require.NoError(t, err)
+				kept += len(events)
+			}
+			assert.Equal(t, tt.kept, kept)
+		})
+	}
+}
+
+func TestDedupSyslogFromTwoPorts(t *testing.T) {
+	processor, err := NewProcessor(ProcessorConfig{Type: "dedup"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		line string
+		keep bool
+	}{
+		// RFC5424 with the nil timestamp, stamped on reception
+		{"<13>1 - host app - - - duplicated", true},
+		{"<13>1 - host app - - - duplicated", false},
+		{"<13>1 - host app - - - other", true},
+	}
+	for i, tt := range tests {
+		logParts := parseSyslogLine(t, syslog.RFC5424, tt.line)
+		logParts["client"] = []string{"192.0.2.10:51514", "192.0.2.10:51515", "192.0.2.10:51516"}[i]
+		events, err := processor.Process(NewEventFromMap(newSyslogMessage("RFC5424", logParts, true).ToMap()))
+		require.NoError(t, err)
+		assert.Equal(t, tt.keep, len(events) == 1, tt.line)
+	}
+}
@@ -0,0 +1,269 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/bytedance/sonic"
+	"github.com/linkedin/goavro/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Output encodings
+const (
+	EncodingJSON     = "json"
+	EncodingAvro     = "avro"
+	EncodingProtobuf = "protobuf"
+	EncodingMsgpack  = "msgpack"
+	EncodingRaw      = "raw"
+)
+
+// EncoderConfig represents the serialization of records sent to a Kafka
+// destination
+type EncoderConfig struct {
+	Type string `yaml:"type"`
+	// Schema is the Avro schema file, or for JSON the JSON Schema file
+	// registered with SchemaRegistry
+	Schema string `yaml:"schema,omitempty"`
+	// Descriptor is a protobuf FileDescriptorSet file (protoc -o) holding
+	// Message
+	Descriptor string `yaml:"descriptor,omitempty"`
+	Message    string `yaml:"message,omitempty"`
+	// Field is the field sent by the raw encoding (default content)
+	Field string `yaml:"field,omitempty"`
+	// SchemaRegistry frames Avro and JSON records in the Confluent wire
+	// format
+	SchemaRegistry *SchemaRegistryConfig `yaml:"schema_registry,omitempty"`
+}
+
+// Encoder serializes events into record values. Errors mean that the event
+// does not match the configured schema.
+type Encoder interface {
+	Encode(evt *Event) ([]byte, error)
+}
+
+// NewEncoder creates the encoder described by cfg for records sent to topic.
+// A nil cfg selects JSON.
+func NewEncoder(cfg *EncoderConfig, topic string) (Encoder, error) {
+	if cfg == nil {
+		return jsonEncoder{}, nil
+	}
+	encoder, err := newEncoder(cfg)
+	if err != nil || cfg.SchemaRegistry == nil {
+		return encoder, err
+	}
+
+	var schemaType string
+	switch cfg.Type {
+	case EncodingAvro:
+		schemaType = "AVRO"
+	case "", EncodingJSON:
+		schemaType = "JSON"
+	default:
+		return nil, fmt.Errorf("schema registry is not supported for %s encoding", cfg.Type)
+	}
+	if cfg.Schema == "" {
+		return nil, fmt.Errorf("schema registry requires a schema file")
+	}
+	schema, err := os.ReadFile(cfg.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	return newRegistryEncoder(encoder, cfg.SchemaRegistry, schemaType, string(schema), topic)
+}
+
+func newEncoder(cfg *EncoderConfig) (Encoder, error) {
+	switch cfg.Type {
+	case "", EncodingJSON:
+		return jsonEncoder{}, nil
+	case EncodingAvro:
+		return newAvroEncoder(cfg.Schema)
+	case EncodingProtobuf:
+		return newProtobufEncoder(cfg.Descriptor, cfg.Message)
+	case EncodingMsgpack:
+		return msgpackEncoder{}, nil
+	case EncodingRaw:
+		field := cfg.Field
+		if field == "" {
+			field = "content"
+		}
+		return rawEncoder{field: StringToList(field)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding: %s", cfg.Type)
+	}
+}
+
+// jsonEncoder sends the event as JSON.
+type jsonEncoder struct{}
+
+func (jsonEncoder) Encode(evt *Event) ([]byte, error) {
+	return evt.Bytes()
+}
+
+// avroEncoder sends the event as Avro binary. Events are read as standard
+// JSON, so union values need no type wrapper, and top level fields that are
+// not in the record schema are ignored.
+type avroEncoder struct {
+	codec  *goavro.Codec
+	fields map[string]struct{}
+}
+
+func newAvroEncoder(path string) (*avroEncoder, error) {
+	if path == "" {
+		return nil, fmt.Errorf("avro encoding requires a schema file")
+	}
+	schema, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read avro schema: %w", err)
+	}
+	codec, err := goavro.NewCodecForStandardJSONFull(string(schema))
+	if err != nil {
+		return nil, fmt.Errorf("invalid avro schema %s: %w", path, err)
+	}
+
+	var record struct {
+		Type   string `json:"type"`
+		Fields []struct {
+			Name string `json:"name"`
+		} `json:"fields"`
+	}
+	if err := sonic.Unmarshal(schema, &record); err != nil || record.Type != "record" {
+		return nil, fmt.Errorf("avro schema %s must be a record", path)
+	}
+	e := &avroEncoder{codec: codec, fields: make(map[string]struct{}, len(record.Fields))}
+	for _, f := range record.Fields {
+		e.fields[f.Name] = struct{}{}
+	}
+	return e, nil
+}
+
+func (e *avroEncoder) Encode(evt *Event) ([]byte, error) {
+	data, err := evt.Data()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]interface{}, len(e.fields))
+	for k, v := range data {
+		if _, ok := e.fields[k]; ok {
+			known[k] = v
+		}
+	}
+	raw, err := sonic.Marshal(known)
+	if err != nil {
+		return nil, err
+	}
+	native, _, err := e.codec.NativeFromTextual(raw)
+	if err != nil {
+		return nil, err
+	}
+	return e.codec.BinaryFromNative(nil, native)
+}
+
+// protobufEncoder sends the event as the binary form of a protobuf message
+// read from its JSON mapping. Fields unknown to the message are ignored.
+type protobufEncoder struct {
+	desc protoreflect.MessageDescriptor
+}
+
+func newProtobufEncoder(path, message string) (*protobufEncoder, error) {
+	if path == "" || message == "" {
+		return nil, fmt.Errorf("protobuf encoding requires a descriptor file and a message")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read protobuf descriptor: %w", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(content, &set); err != nil {
+		return nil, fmt.Errorf("invalid protobuf descriptor %s: %w", path, err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf descriptor %s: %w", path, err)
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		return nil, fmt.Errorf("protobuf message %s: %w", message, err)
+	}
+	msgDesc, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a protobuf message", message)
+	}
+	return &protobufEncoder{desc: msgDesc}, nil
+}
+
+func (e *protobufEncoder) Encode(evt *Event) ([]byte, error) {
+	raw, err := evt.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	msg := dynamicpb.NewMessage(e.desc)
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(raw, msg); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(msg)
+}
+
+// msgpackEncoder sends the event as MessagePack. Integral JSON numbers are
+// encoded as integers.
+type msgpackEncoder struct{}
+
+func (msgpackEncoder) Encode(evt *Event) ([]byte, error) {
+	data, err := evt.Data()
+	if err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(msgpackValue(data))
+}
+
+// msgpackValue returns a copy of value with integral floats converted to
+// int64.
+func msgpackValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+			return int64(v)
+		}
+		return v
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			res[k] = msgpackValue(item)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			res[i] = msgpackValue(item)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+// rawEncoder sends only the string value of one field, such as the original
+// syslog content.
+type rawEncoder struct {
+	field []string
+}
+
+func (e rawEncoder) Encode(evt *Event) ([]byte, error) {
+	data, err := evt.Data()
+	if err != nil {
+		return nil, err
+	}
+	value, ok := GetField(data, e.field)
+	if !ok {
+		return nil, fmt.Errorf("field %s not found", strings.Join(e.field, "."))
+	}
+	return []byte(AnyToString(value)), nil
+}
@@ -0,0 +1,205 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bytedance/sonic"
+	"github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const testAvroSchema = `{
+  "type": "record",
+  "name": "Log",
+  "fields": [
+    {"name": "host", "type": "string"},
+    {"name": "count", "type": "long"},
+    {"name": "user", "type": ["null", "string"], "default": null}
+  ]
+}`
+
+// writeTestDescriptor writes a FileDescriptorSet holding the message
+// test.Log with a string host and an int64 count.
+func writeTestDescriptor(t *testing.T) (string, protoreflect.MessageDescriptor) {
+	t.Helper()
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Type:     typ.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("log.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Log"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("host", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+			},
+		}},
+	}
+	fd, err := protodesc.NewFile(file, nil)
+	require.NoError(t, err)
+
+	raw, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "log.pb")
+	require.NoError(t, os.WriteFile(path, raw, 0o600))
+	return path, fd.Messages().ByName("Log")
+}
+
+func TestEncoders(t *testing.T) {
+	avroSchema := filepath.Join(t.TempDir(), "log.avsc")
+	require.NoError(t, os.WriteFile(avroSchema, []byte(testAvroSchema), 0o600))
+	descriptor, msgDesc := writeTestDescriptor(t)
+
+	codec, err := goavro.NewCodecForStandardJSONFull(testAvroSchema)
+	require.NoError(t, err)
+	decodeAvro := func(t *testing.T, raw []byte) string {
+		native, _, err := codec.NativeFromBinary(raw)
+		require.NoError(t, err)
+		text, err := codec.TextualFromNative(nil, native)
+		require.NoError(t, err)
+		return string(text)
+	}
+	decodeProtobuf := func(t *testing.T, raw []byte) string {
+		msg := dynamicpb.NewMessage(msgDesc)
+		require.NoError(t, proto.Unmarshal(raw, msg))
+		text, err := protojson.Marshal(msg)
+		require.NoError(t, err)
+		return string(text)
+	}
+	decodeMsgpack := func(t *testing.T, raw []byte) string {
+		var value interface{}
+		require.NoError(t, msgpack.Unmarshal(raw, &value))
+		text, err := sonic.Marshal(value)
+		require.NoError(t, err)
+		return string(text)
+	}
+
+	tests := []struct {
+		name   string
+		cfg    *EncoderConfig
+		in     string
+		decode func(t *testing.T, raw []byte) string
+		want   string
+		err    bool
+	}{
+		{name: "default json", in: `{"host":"web1","count":3}`, want: `{"host":"web1","count":3}`},
+		{name: "json", cfg: &EncoderConfig{Type: EncodingJSON}, in: `{"host":"web1"}`, want: `{"host":"web1"}`},
+		{
+			name:   "avro ignores unknown fields",
+			cfg:    &EncoderConfig{Type: EncodingAvro, Schema: avroSchema},
+			in:     `{"host":"web1","count":3,"extra":true}`,
+			decode: decodeAvro,
+			want:   `{"host":"web1","count":3,"user":null}`,
+		},
+		{
+			name:   "avro union without wrapper",
+			cfg:    &EncoderConfig{Type: EncodingAvro, Schema: avroSchema},
+			in:     `{"host":"web1","count":3,"user":"alice"}`,
+			decode: decodeAvro,
+			want:   `{"host":"web1","count":3,"user":"alice"}`,
+		},
+		{name: "avro missing field", cfg: &EncoderConfig{Type: EncodingAvro, Schema: avroSchema}, in: `{"count":3}`, err: true},
+		{name: "avro wrong type", cfg: &EncoderConfig{Type: EncodingAvro, Schema: avroSchema}, in: `{"host":"web1","count":"three"}`, err: true},
+		{
+			name:   "protobuf ignores unknown fields",
+			cfg:    &EncoderConfig{Type: EncodingProtobuf, Descriptor: descriptor, Message: "test.Log"},
+			in:     `{"host":"web1","count":3,"extra":true}`,
+			decode: decodeProtobuf,
+			want:   `{"host":"web1","count":"3"}`,
+		},
+		{
+			name: "protobuf wrong type",
+			cfg:  &EncoderConfig{Type: EncodingProtobuf, Descriptor: descriptor, Message: "test.Log"},
+			in:   `{"host":1}`,
+			err:  true,
+		},
+		{
+			name:   "msgpack",
+			cfg:    &EncoderConfig{Type: EncodingMsgpack},
+			in:     `{"host":"web1","count":3,"ratio":0.5,"tags":["a",1]}`,
+			decode: decodeMsgpack,
+			want:   `{"host":"web1","count":3,"ratio":0.5,"tags":["a",1]}`,
+		},
+		{name: "raw default field", cfg: &EncoderConfig{Type: EncodingRaw}, in: `{"content":"hello"}`, want: "hello"},
+		{name: "raw nested field", cfg: &EncoderConfig{Type: EncodingRaw, Field: "a.b"}, in: `{"a":{"b":42}}`, want: "42"},
+		{name: "raw missing field", cfg: &EncoderConfig{Type: EncodingRaw}, in: `{"message":"hello"}`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoder, err := NewEncoder(tt.cfg, "logs")
+			require.NoError(t, err)
+			evt, err := ParseEvent([]byte(tt.in))
+			require.NoError(t, err)
+
+			raw, err := encoder.Encode(evt)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			switch {
+			case tt.decode != nil:
+				assert.JSONEq(t, tt.want, tt.decode(t, raw))
+			case tt.cfg != nil && tt.cfg.Type == EncodingRaw:
+				assert.Equal(t, tt.want, string(raw))
+			default:
+				assert.JSONEq(t, tt.want, string(raw))
+			}
+		})
+	}
+}
+
+func TestMsgpackIntegers(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want interface{}
+	}{
+		{float64(3), int64(3)},
+		{float64(-3), int64(-3)},
+		{1.5, 1.5},
+		{1e19, 1e19},
+		{"3", "3"},
+		{
+			map[string]interface{}{"a": []interface{}{float64(1), 2.5}},
+			map[string]interface{}{"a": []interface{}{int64(1), 2.5}},
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, msgpackValue(tt.in))
+	}
+}
+
+func TestNewEncoderErrors(t *testing.T) {
+	descriptor, _ := writeTestDescriptor(t)
+	for _, cfg := range []EncoderConfig{
+		{Type: "xml"},
+		{Type: EncodingAvro},
+		{Type: EncodingAvro, Schema: "/nonexistent.avsc"},
+		{Type: EncodingProtobuf, Descriptor: descriptor},
+		{Type: EncodingProtobuf, Descriptor: descriptor, Message: "test.Missing"},
+		{Type: EncodingMsgpack, SchemaRegistry: &SchemaRegistryConfig{URL: "http://localhost:8081"}},
+		{Type: EncodingJSON, SchemaRegistry: &SchemaRegistryConfig{URL: "http://localhost:8081"}},
+	} {
+		_, err := NewEncoder(&cfg, "logs")
+		assert.Error(t, err, cfg)
+	}
+}
@@ -0,0 +1,171 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/bytedance/sonic"
+)
+
+var errNotObject = errors.New("message is not a JSON object")
+
+// Event is a single message travelling from a source to a Kafka producer.
+// It carries the serialized bytes together with a lazily parsed
+// representation, so key extraction and later stages share one parse.
+type Event struct {
+	raw    []byte
+	data   map[string]interface{}
+	parsed bool
+	dirty  bool
+	err    error
+
+	// topic and headers route the event to a topic other than the
+	// destination's, such as a quarantine topic
+	topic   string
+	headers map[string]string
+}
+
+// NewEvent creates an event from serialized JSON. The bytes are parsed on
+// the first call to Data.
+func NewEvent(raw []byte) *Event {
+	return &Event{raw: raw}
+}
+
+// NewEventFromMap creates an event from already structured data. The bytes
+// are produced on the first call to Bytes.
+func NewEventFromMap(data map[string]interface{}) *Event {
+	return &Event{data: data, parsed: true, dirty: true}
+}
+
+// ParseEvent validates raw as JSON and returns an event holding both the
+// bytes and the parsed value. Valid JSON that is not an object is accepted,
+// but Data will report an error for it.
+func ParseEvent(raw []byte) (*Event, error) {
+	var value interface{}
+	if err := sonic.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+
+	e := &Event{raw: raw, parsed: true}
+	if data, ok := value.(map[string]interface{}); ok {
+		e.data = data
+	} else {
+		e.err = errNotObject
+	}
+	return e, nil
+}
+
+// Data returns the parsed JSON object. Callers that modify the returned map
+// must call MarkDirty so that Bytes reflects the change.
+func (e *Event) Data() (map[string]interface{}, error) {
+	if !e.parsed {
+		e.parsed = true
+		if err := sonic.Unmarshal(e.raw, &e.data); err != nil {
+			e.err = err
+		} else if e.data == nil {
+			e.err = errNotObject
+		}
+	}
+	return e.data, e.err
+}
+
+// MarkDirty records that the parsed data was modified.
+func (e *Event) MarkDirty() {
+	e.dirty = true
+}
+
+// Bytes returns the serialized event, re-encoding the parsed data only when
+// it was created from a map or modified since parsing.
+func (e *Event) Bytes() ([]byte, error) {
+	if e.dirty {
+		raw, err := sonic.Marshal(e.data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal event: %w", err)
+		}
+		e.raw = raw
+		e.dirty = false
+	}
+	return e.raw, nil
+}
+
+// SetTopic routes the event to topic instead of the destination topic. Such
+// events skip destination processors and are produced as received.
+func (e *Event) SetTopic(topic string) {
+	e.topic = topic
+}
+
+// Topic returns the topic set with SetTopic, or "" for the destination
+// topic.
+func (e *Event) Topic() string {
+	return e.topic
+}
+
+// SetHeader sets a record header sent with the event.
+func (e *Event) SetHeader(key, value string) {
+	if e.headers == nil {
+		e.headers = make(map[string]string)
+	}
+	e.headers[key] = value
+}
+
+// Clone returns an independent copy of the event, so that destinations can
+// modify their copies. The data is re-parsed from the serialized bytes.
+func (e *Event) Clone() (*Event, error) {
+	raw, err := e.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	c := &Event{raw: raw, topic: e.topic}
+	if e.parsed && e.err != nil {
+		c.parsed, c.err = true, e.err
+	}
+	if len(e.headers) > 0 {
+		c.headers = make(map[string]string, len(e.headers))
+		for k, v := range e.headers {
+			c.headers[k] = v
+		}
+	}
+	return c, nil
+}
+
+// Tee copies every event from in to each of outs, keyed by destination id.
+// Each copy is sent to the destination queue, whose overflow policy decides
+// whether a full queue blocks or drops it. A copy that cannot be made is
+// skipped for that destination only and counted under
+// queue.<id>.clone_errors.
+func Tee(in <-chan *Event, outs map[string]chan *Event, log *slog.Logger) {
+	for evt := range in {
+		// Copy before sending, as destinations modify their events; the
+		// last destination gets the original
+		i := 0
+		for id, out := range outs {
+			i++
+			e := evt
+			if i < len(outs) {
+				c, err := evt.Clone()
+				if err != nil {
+					AddMetric("queue."+id+".clone_errors", 1)
+					log.Error("Error copying event", "destination", id, "error", err)
+					continue
+				}
+				e = c
+			}
+			out <- e
+		}
+	}
+}
+
+// sendEvent runs evt through pipeline and sends the resulting events to
+// msgChan. Events failing a processor are logged and dropped, and the error
+// is returned.
+func sendEvent(pipeline *Pipeline, evt *Event, msgChan chan *Event, log *slog.Logger) error {
+	events, err := pipeline.Process(evt)
+	if err != nil {
+		log.Error("Error processing event, dropping it", "error", err)
+	}
+	for _, e := range events {
+		msgChan <- e
+	}
+	return err
+}
@@ -0,0 +1,272 @@
+package common
+
+import (
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var benchPayload = []byte(`{"user":{"id":"42","name":"alice"},"action":"login","status":200,"tags":["a","b","c"],"meta":{"ip":"10.0.0.1","agent":"curl/8.0"}}`)
+
+var benchKey = []string{"user", "id"}
+
+func benchSyslogMessage() map[string]interface{} {
+	return map[string]interface{}{
+		"hostname":  "web-1",
+		"app_name":  "sshd",
+		"proc_id":   "1234",
+		"facility":  "auth",
+		"severity":  "info",
+		"timestamp": "2024-05-01T12:00:00Z",
+		"content":   "Accepted publickey for alice from 10.0.0.1 port 52100 ssh2",
+	}
+}
+
+// BenchmarkEventWebhookDoubleParse is the former webhook path: the body is
+// unmarshalled once for validation and again by the producer for the key.
+func BenchmarkEventWebhookDoubleParse(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var value interface{}
+		if err := sonic.Unmarshal(benchPayload, &value); err != nil {
+			b.Fatal(err)
+		}
+		data := make(map[string]interface{})
+		if err := sonic.Unmarshal(benchPayload, &data); err != nil {
+			b.Fatal(err)
+		}
+		GetCheckData(data, benchKey)
+	}
+}
+
+// BenchmarkEventWebhookParseOnce validates the body and extracts the key
+// from a single parse.
+func BenchmarkEventWebhookParseOnce(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		evt, err := ParseEvent(benchPayload)
+		if err != nil {
+			b.Fatal(err)
+		}
+		data, _ := evt.Data()
+		GetCheckData(data, benchKey)
+		if _, err := evt.Bytes(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEventSyslogMarshalReparse is the former syslog path: the message
+// map is marshalled and the producer parses the bytes again for the key.
+func BenchmarkEventSyslogMarshalReparse(b *testing.B) {
+	b.ReportAllocs()
+	key := []string{"hostname"}
+	for i := 0; i < b.N; i++ {
+		raw, err := sonic.Marshal(benchSyslogMessage())
+		if err != nil {
+			b.Fatal(err)
+		}
+		data := make(map[string]interface{})
+		if err := sonic.Unmarshal(raw, &data); err != nil {
+			b.Fatal(err)
+		}
+		GetCheckData(data, key)
+	}
+}
+
+// BenchmarkEventSyslogFromMap extracts the key from the message map and
+// marshals it once.
+func BenchmarkEventSyslogFromMap(b *testing.B) {
+	b.ReportAllocs()
+	key := []string{"hostname"}
+	for i := 0; i < b.N; i++ {
+		evt := NewEventFromMap(benchSyslogMessage())
+		data, _ := evt.Data()
+		GetCheckData(data, key)
+		if _, err := evt.Bytes(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEventPipelineNoop runs a processor that writes nothing, which
+// must not cause the event to be re-encoded.
+func BenchmarkEventPipelineNoop(b *testing.B) {
+	pipeline, err := NewPipeline([]ProcessorConfig{{Type: "add", Field: "action", Value: "ignored"}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		evt, err := ParseEvent(benchPayload)
+		if err != nil {
+			b.Fatal(err)
+		}
+		events, err := pipeline.Process(evt)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := events[0].Bytes(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEventBytesReusedWhenUnchanged(t *testing.T) {
+	tests := []struct {
+		name      string
+		processor ProcessorConfig
+		changed   bool
+	}{
+		{"add existing", ProcessorConfig{Type: "add", Field: "action", Value: "x"}, false},
+		{"add new", ProcessorConfig{Type: "add", Field: "new", Value: "x"}, true},
+		{"remove missing", ProcessorConfig{Type: "remove", Field: "missing"}, false},
+		{"remove existing", ProcessorConfig{Type: "remove", Field: "action"}, true},
+		{"lowercase unchanged", ProcessorConfig{Type: "lowercase", Field: "action"}, false},
+		{"uppercase", ProcessorConfig{Type: "uppercase", Field: "action"}, true},
+		{"rename missing", ProcessorConfig{Type: "rename", Field: "missing", Target: "other"}, false},
+		{"convert same type", ProcessorConfig{Type: "convert", Field: "action", To: "string"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, err := NewPipeline([]ProcessorConfig{tt.processor})
+			require.NoError(t, err)
+			evt, err := ParseEvent(benchPayload)
+			require.NoError(t, err)
+
+			events, err := pipeline.Process(evt)
+			require.NoError(t, err)
+			require.Len(t, events, 1)
+			raw, err := events[0].Bytes()
+			require.NoError(t, err)
+
+			// Unchanged events keep the exact received bytes
+			assert.Equal(t, !tt.changed, &raw[0] == &benchPayload[0])
+		})
+	}
+}
+
+func TestEventClone(t *testing.T) {
+	evt, err := ParseEvent(benchPayload)
+	require.NoError(t, err)
+	evt.SetTopic("quarantine")
+	evt.SetHeader("reason", "test")
+
+	c, err := evt.Clone()
+	require.NoError(t, err)
+	data, err := c.Data()
+	require.NoError(t, err)
+	data["action"] = "changed"
+	c.MarkDirty()
+	c.SetHeader("reason", "other")
+
+	orig, err := evt.Data()
+	require.NoError(t, err)
+	assert.Equal(t, "login", orig["action"])
+	assert.Equal(t, "quarantine", c.Topic())
+	assert.Equal(t, "test", evt.headers["reason"])
+}
+
+func TestTee(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      map[string]interface{}
+		delivered int
+		errors    int64
+	}{
+		{"copied to each destination", map[string]interface{}{"n": 1}, 2, 0},
+		// The original, which cannot be serialized, still reaches one
+		// destination, while copying it for the other fails
+		{"copy error skips the destination", map[string]interface{}{"f": func() {}}, 1, 1},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids := []string{fmt.Sprintf("tee%d_a", i), fmt.Sprintf("tee%d_b", i)}
+			cloneErrors := func() int64 {
+				var n int64
+				for _, id := range ids {
+					if v, ok := Metrics.Get("queue." + id + ".clone_errors").(*expvar.Int); ok {
+						n += v.Value()
+					}
+				}
+				return n
+			}
+			before := cloneErrors()
+			outs := map[string]chan *Event{ids[0]: make(chan *Event, 1), ids[1]: make(chan *Event, 1)}
+			in := make(chan *Event)
+			done := make(chan struct{})
+			go func() {
+				Tee(in, outs, ComponentLogger("test"))
+				close(done)
+			}()
+			in <- NewEventFromMap(tt.data)
+			close(in)
+			<-done
+
+			var events []*Event
+			for _, id := range ids {
+				if len(outs[id]) > 0 {
+					events = append(events, <-outs[id])
+				}
+			}
+			assert.Len(t, events, tt.delivered)
+			assert.Equal(t, tt.errors, cloneErrors()-before)
+			if len(events) == 2 {
+				data, err := events[0].Data()
+				require.NoError(t, err)
+				data["n"] = 2
+				data, err = events[1].Data()
+				require.NoError(t, err)
+				assert.EqualValues(t, 1, data["n"])
+			}
+		})
+	}
+}
+
+func TestTeeQueuePolicies(t *testing.T) {
+	// A full drop_newest queue drops its copies without holding back the
+	// other destination
+	dropping, err := NewQueue("tee_dropping", &QueueConfig{Size: 1, Overflow: OverflowDropNewest})
+	require.NoError(t, err)
+	release := make(chan struct{})
+	dropping.Run(func(*Event) { <-release })
+	defer func() {
+		close(release)
+		dropping.Stop()
+	}()
+
+	blocking, err := NewQueue("tee_blocking", &QueueConfig{Size: 1})
+	require.NoError(t, err)
+	delivered := make(chan *Event)
+	blocking.Run(func(evt *Event) { delivered <- evt })
+	defer blocking.Stop()
+
+	in := make(chan *Event)
+	go Tee(in, map[string]chan *Event{"tee_dropping": dropping.In(), "tee_blocking": blocking.In()}, ComponentLogger("test"))
+	defer close(in)
+
+	const n = 200
+	go func() {
+		for i := 0; i < n; i++ {
+			in <- NewEventFromMap(map[string]interface{}{"n": i})
+		}
+	}()
+	for i := 0; i < n; i++ {
+		select {
+		case evt := <-delivered:
+			data, err := evt.Data()
+			require.NoError(t, err)
+			assert.EqualValues(t, i, data["n"])
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d events, want %d", i, n)
+		}
+	}
+	assert.NotNil(t, Metrics.Get("queue.tee_dropping.dropped"))
+}
@@ -0,0 +1,97 @@
+package common
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// filterProcessor keeps events matching any include condition (when
+// configured) and drops events matching any exclude condition.
+func filterProcessor(cfg ProcessorConfig) (predicate, error) {
+	if len(cfg.Include) == 0 && len(cfg.Exclude) == 0 {
+		return nil, fmt.Errorf("include or exclude is required")
+	}
+	include, err := newConditions(cfg.Include)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := newConditions(cfg.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(data map[string]interface{}) bool {
+		if len(include) > 0 && !matchAny(include, data) {
+			AddMetric("filter.dropped", 1)
+			return false
+		}
+		if matchAny(exclude, data) {
+			AddMetric("filter.dropped", 1)
+			return false
+		}
+		return true
+	}, nil
+}
+
+// sampleProcessor keeps a random ratio of events and/or at most per_second
+// events per key, where the key is the value of field (all events share a
+// bucket when no field is configured).
+func sampleProcessor(cfg ProcessorConfig) (predicate, error) {
+	if cfg.Ratio == 0 && cfg.PerSecond == 0 {
+		return nil, fmt.Errorf("ratio or per_second is required")
+	}
+	if cfg.Ratio < 0 || cfg.Ratio > 1 {
+		return nil, fmt.Errorf("ratio must be between 0 and 1")
+	}
+	if cfg.PerSecond < 0 {
+		return nil, fmt.Errorf("per_second must not be negative")
+	}
+
+	var limiter *keyedLimiter
+	if cfg.PerSecond > 0 {
+		limiter = newKeyedLimiter(cfg.PerSecond, cfg.Burst)
+	}
+	var key []string
+	if cfg.Field != "" {
+		key = StringToList(cfg.Field)
+	}
+
+	return func(data map[string]interface{}) bool {
+		if cfg.Ratio > 0 && rand.Float64() >= cfg.Ratio {
+			AddMetric("sample.dropped", 1)
+			return false
+		}
+		if limiter != nil {
+			var bucket string
+			if key != nil {
+				bucket, _ = GetCheckData(data, key)
+			}
+			if !limiter.Allow(bucket) {
+				AddMetric("sample.dropped", 1)
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func newConditions(cfgs []ConditionConfig) ([]*Condition, error) {
+	conds := make([]*Condition, 0, len(cfgs))
+	for i := range cfgs {
+		cond, err := NewCondition(&cfgs[i])
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+	return conds, nil
+}
+
+func matchAny(conds []*Condition, data map[string]interface{}) bool {
+	for _, cond := range conds {
+		if cond.Match(data) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCondition(t *testing.T) {
+	str := func(s string) *string { return &s }
+	num := func(f float64) *float64 { return &f }
+	yes, no := true, false
+
+	data := map[string]interface{}{
+		"level":  "error",
+		"status": float64(503),
+		"user":   map[string]interface{}{"name": "alice"},
+		"query":  "a=1&b=2",
+	}
+
+	tests := []struct {
+		name string
+		cfg  ConditionConfig
+		want bool
+	}{
+		{"equals", ConditionConfig{Field: "level", Equals: str("error")}, true},
+		{"equals other", ConditionConfig{Field: "level", Equals: str("info")}, false},
+		{"not equals", ConditionConfig{Field: "level", Equals: str("info"), Not: true}, true},
+		{"in", ConditionConfig{Field: "level", In: []string{"warn", "error"}}, true},
+		{"regex", ConditionConfig{Field: "user.name", Regex: "^al"}, true},
+		{"exists", ConditionConfig{Field: "user.name", Exists: &yes}, true},
+		{"not exists", ConditionConfig{Field: "missing", Exists: &no}, true},
+		{"missing field", ConditionConfig{Field: "missing", Equals: str("")}, false},
+		{"numeric range", ConditionConfig{Field: "status", Gte: num(500), Lt: num(600)}, true},
+		{"numeric out of range", ConditionConfig{Field: "status", Lt: num(500)}, false},
+		{"numeric on string", ConditionConfig{Field: "level", Gt: num(0)}, false},
+		{"query string", ConditionConfig{Field: "query.b", Equals: str("2")}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, err := NewCondition(&tt.cfg)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, cond.Match(data))
+		})
+	}
+}
+
+func TestConditionErrors(t *testing.T) {
+	for _, cfg := range []ConditionConfig{
+		{},
+		{Field: "a"},
+		{Field: "a", Regex: "("},
+	} {
+		_, err := NewCondition(&cfg)
+		assert.Error(t, err)
+	}
+}
+
+func TestFilterProcessor(t *testing.T) {
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name string
+		cfg  ProcessorConfig
+		in   map[string]interface{}
+		keep bool
+	}{
+		{
+			name: "include match",
+			cfg:  ProcessorConfig{Include: []ConditionConfig{{Field: "level", In: []string{"warn", "error"}}}},
+			in:   map[string]interface{}{"level": "error"},
+			keep: true,
+		},
+		{
+			name: "include no match",
+			cfg:  ProcessorConfig{Include: []ConditionConfig{{Field: "level", In: []string{"warn", "error"}}}},
+			in:   map[string]interface{}{"level": "info"},
+			keep: false,
+		},
+		{
+			name: "exclude match",
+			cfg:  ProcessorConfig{Exclude: []ConditionConfig{{Field: "app", Equals: str("healthcheck")}}},
+			in:   map[string]interface{}{"app": "healthcheck"},
+			keep: false,
+		},
+		{
+			name: "exclude wins over include",
+			cfg: ProcessorConfig{
+				Include: []ConditionConfig{{Field: "level", Equals: str("error")}},
+				Exclude: []ConditionConfig{{Field: "app", Equals: str("healthcheck")}},
+			},
+			in:   map[string]interface{}{"level": "error", "app": "healthcheck"},
+			keep: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Type = "filter"
+			processor, err := NewProcessor(tt.cfg)
+			require.NoError(t, err)
+			events, err := processor.Process(NewEventFromMap(tt.in))
+			require.NoError(t, err)
+			assert.Equal(t, tt.keep, len(events) == 1)
+		})
+	}
+}
+
+func TestSampleProcessor(t *testing.T) {
+	count := func(cfg ProcessorConfig, events []map[string]interface{}) int {
+		cfg.Type = "sample"
+		processor, err := NewProcessor(cfg)
+		require.NoError(t, err)
+		kept := 0
+		for _, data := range events {
+			out, err := processor.Process(NewEventFromMap(data))
+			require.NoError(t, err)
+			kept += len(out)
+		}
+		return kept
+	}
+	same := func(n int, data map[string]interface{}) []map[string]interface{} {
+		res := make([]map[string]interface{}, n)
+		for i := range res {
+			res[i] = data
+		}
+		return res
+	}
+
+	assert.Equal(t, 100, count(ProcessorConfig{Ratio: 1}, same(100, map[string]interface{}{})))
+
+	kept := count(ProcessorConfig{Ratio: 0.5}, same(10000, map[string]interface{}{}))
+	assert.InDelta(t, 5000, kept, 500)
+
+	// The burst is consumed at once, refilling takes far longer than the test
+	assert.Equal(t, 5, count(ProcessorConfig{PerSecond: 0.001, Burst: 5}, same(20, map[string]interface{}{})))
+
+	// Each value of field has its own bucket
+	events := append(same(10, map[string]interface{}{"host": "a"}), same(10, map[string]interface{}{"host": "b"})...)
+	assert.Equal(t, 4, count(ProcessorConfig{PerSecond: 0.001, Burst: 2, Field: "host"}, events))
+}
+
+func TestSampleProcessorErrors(t *testing.T) {
+	for _, cfg := range []ProcessorConfig{
+		{Type: "sample"},
+		{Type: "sample", Ratio: 1.5},
+		{Type: "sample", Ratio: -0.1},
+		{Type: "sample", PerSecond: -1},
+		{Type: "filter"},
+	} {
+		_, err := NewProcessor(cfg)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,257 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+const (
+	defaultDNSCacheSize   = 10000
+	defaultDNSCacheTTL    = time.Hour
+	defaultDNSNegativeTTL = 5 * time.Minute
+	defaultDNSTimeout     = time.Second
+	maxDNSLookups         = 16
+)
+
+// geoRecord holds the fields read from City, Country and ASN databases.
+// Fields missing from a database are left empty.
+type geoRecord struct {
+	Country struct {
+		IsoCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+	ASN    uint   `maxminddb:"autonomous_system_number"`
+	ASNOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// geoipProcessor adds country, city, ASN and optionally reverse DNS
+// information for the IP addresses in field / fields. Results are stored
+// under target, or <field>_geo when no target is given.
+type geoipProcessor struct {
+	mapProcessor
+	readers []*maxminddb.Reader
+}
+
+func newGeoIPProcessor(cfg ProcessorConfig) (*geoipProcessor, error) {
+	paths, err := fieldPaths(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Target != "" && len(paths) > 1 {
+		return nil, fmt.Errorf("target requires a single field")
+	}
+	if cfg.Database == "" && cfg.ASNDatabase == "" && !cfg.ReverseDNS {
+		return nil, fmt.Errorf("database, asn_database or reverse_dns is required")
+	}
+
+	if cfg.ReverseDNS && cfg.DNSCacheSize < 0 {
+		return nil, fmt.Errorf("dns_cache_size must not be negative")
+	}
+
+	p := &geoipProcessor{}
+	for _, path := range []string{cfg.Database, cfg.ASNDatabase} {
+		if path == "" {
+			continue
+		}
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			_ = p.Close()
+			return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+		}
+		p.readers = append(p.readers, reader)
+	}
+
+	var dns *dnsCache
+	if cfg.ReverseDNS {
+		dns = newDNSCache(cfg.DNSCacheSize, cfg.DNSTTL, cfg.DNSNegativeTTL, cfg.DNSTimeout)
+	}
+
+	targets := make([][]string, len(paths))
+	for i, path := range paths {
+		if cfg.Target != "" {
+			targets[i] = StringToList(cfg.Target)
+		} else {
+			targets[i] = append(append([]string{}, path[:len(path)-1]...), path[len(path)-1]+"_geo")
+		}
+	}
+
+	p.mapProcessor = func(data map[string]interface{}) (bool, error) {
+		changed := false
+		for i, path := range paths {
+			value, ok := GetField(data, path)
+			if !ok {
+				continue
+			}
+			ip := net.ParseIP(clientIP(AnyToString(value)))
+			if ip == nil {
+				continue
+			}
+
+			info := make(map[string]interface{})
+			for _, reader := range p.readers {
+				var rec geoRecord
+				if err := reader.Lookup(ip, &rec); err != nil {
+					AddMetric("geoip.errors", 1)
+					continue
+				}
+				addGeoRecord(info, &rec)
+			}
+			if dns != nil {
+				if name := dns.Lookup(ip.String()); name != "" {
+					info["hostname"] = name
+				}
+			}
+			if len(info) > 0 {
+				SetField(data, targets[i], info)
+				changed = true
+			}
+		}
+		return changed, nil
+	}
+	return p, nil
+}
+
+// Close closes the databases.
+func (p *geoipProcessor) Close() error {
+	var errs []error
+	for _, reader := range p.readers {
+		if err := reader.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func addGeoRecord(info map[string]interface{}, rec *geoRecord) {
+	if rec.Country.IsoCode != "" {
+		info["country_code"] = rec.Country.IsoCode
+	}
+	if name := rec.Country.Names["en"]; name != "" {
+		info["country"] = name
+	}
+	if name := rec.City.Names["en"]; name != "" {
+		info["city"] = name
+	}
+	if rec.Location.Latitude != 0 || rec.Location.Longitude != 0 {
+		info["location"] = map[string]interface{}{
+			"lat": rec.Location.Latitude,
+			"lon": rec.Location.Longitude,
+		}
+	}
+	if rec.ASN != 0 {
+		info["asn"] = rec.ASN
+	}
+	if rec.ASNOrg != "" {
+		info["as_org"] = rec.ASNOrg
+	}
+}
+
+type dnsEntry struct {
+	name    string
+	expires time.Time
+}
+
+// dnsCache caches reverse DNS results, including failed lookups, so that
+// busy senders do not cause a lookup per event. Lookups run in the
+// background, at most maxDNSLookups at a time, so a slow resolver never
+// holds up the events waiting on it.
+type dnsCache struct {
+	mu          sync.Mutex
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	timeout     time.Duration
+	entries     map[string]dnsEntry
+	inflight    map[string]struct{}
+	lookupAddr  func(ctx context.Context, addr string) ([]string, error)
+}
+
+func newDNSCache(size int, ttl, negativeTTL, timeout time.Duration) *dnsCache {
+	if size <= 0 {
+		size = defaultDNSCacheSize
+	}
+	if ttl <= 0 {
+		ttl = defaultDNSCacheTTL
+	}
+	if negativeTTL <= 0 {
+		negativeTTL = defaultDNSNegativeTTL
+	}
+	if timeout <= 0 {
+		timeout = defaultDNSTimeout
+	}
+	return &dnsCache{
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		timeout:     timeout,
+		entries:     make(map[string]dnsEntry),
+		inflight:    make(map[string]struct{}),
+		lookupAddr:  net.DefaultResolver.LookupAddr,
+	}
+}
+
+// Lookup returns the cached PTR name of ip without the trailing dot, or ""
+// when there is none. A missing or expired entry starts a lookup in the
+// background; until it completes the previous name, if any, is returned.
+func (c *dnsCache) Lookup(ip string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[ip]
+	if ok && time.Now().Before(e.expires) {
+		return e.name
+	}
+	if _, ok := c.inflight[ip]; ok {
+		return e.name
+	}
+	if len(c.inflight) >= maxDNSLookups {
+		AddMetric("geoip.dns_skipped", 1)
+		return e.name
+	}
+	c.inflight[ip] = struct{}{}
+	go c.resolve(ip)
+	return e.name
+}
+
+func (c *dnsCache) resolve(ip string) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	names, err := c.lookupAddr(ctx, ip)
+	cancel()
+	AddMetric("geoip.dns_lookups", 1)
+
+	var name string
+	ttl := c.negativeTTL
+	if err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+		ttl = c.ttl
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.size {
+		for key, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= c.size {
+			c.entries = make(map[string]dnsEntry)
+		}
+	}
+	c.entries[ip] = dnsEntry{name: name, expires: now.Add(ttl)}
+	delete(c.inflight, ip)
+}
@@ -0,0 +1,139 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitDNS waits until the background lookups of c are done.
+func waitDNS(t *testing.T, c *dnsCache) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.inflight) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestDNSCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		err     error
+		want    string
+		expires time.Duration
+	}{
+		{"name", []string{"host.example.com."}, nil, "host.example.com", time.Hour},
+		{"no name", nil, nil, "", time.Minute},
+		{"failure", nil, errors.New("timeout"), "", time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newDNSCache(0, time.Hour, time.Minute, 0)
+			var calls int32
+			c.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+				atomic.AddInt32(&calls, 1)
+				return tt.names, tt.err
+			}
+
+			// The first event is not held up by the lookup
+			assert.Equal(t, "", c.Lookup("192.0.2.1"))
+			waitDNS(t, c)
+			assert.Equal(t, tt.want, c.Lookup("192.0.2.1"))
+			assert.Equal(t, tt.want, c.Lookup("192.0.2.1"))
+			assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+			assert.WithinDuration(t, time.Now().Add(tt.expires), c.entries["192.0.2.1"].expires, time.Second)
+		})
+	}
+}
+
+func TestDNSCacheDoesNotWait(t *testing.T) {
+	c := newDNSCache(0, 0, 0, 0)
+	release := make(chan struct{})
+	var calls int32
+	c.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []string{"host."}, nil
+	}
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "", c.Lookup("192.0.2.1"))
+	}
+	close(release)
+	waitDNS(t, c)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, "host", c.Lookup("192.0.2.1"))
+}
+
+func TestDNSCacheBoundsLookups(t *testing.T) {
+	c := newDNSCache(0, 0, 0, 0)
+	release := make(chan struct{})
+	var calls int32
+	c.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []string{addr + "."}, nil
+	}
+
+	skipped := func() int64 {
+		if v, ok := Metrics.Get("geoip.dns_skipped").(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := skipped()
+	for i := 0; i < maxDNSLookups+5; i++ {
+		c.Lookup(fmt.Sprintf("192.0.2.%d", i))
+	}
+	assert.Equal(t, int64(5), skipped()-before)
+	close(release)
+	waitDNS(t, c)
+	assert.Equal(t, int32(maxDNSLookups), atomic.LoadInt32(&calls))
+}
+
+func TestDNSCacheRefreshesExpired(t *testing.T) {
+	c := newDNSCache(0, 0, 0, 0)
+	c.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		return []string{"new."}, nil
+	}
+	c.entries["192.0.2.1"] = dnsEntry{name: "old", expires: time.Now().Add(-time.Second)}
+
+	assert.Equal(t, "old", c.Lookup("192.0.2.1"))
+	waitDNS(t, c)
+	assert.Equal(t, "new", c.Lookup("192.0.2.1"))
+}
+
+func TestDNSCacheSize(t *testing.T) {
+	c := newDNSCache(2, 0, 0, 0)
+	c.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		return []string{addr + "."}, nil
+	}
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		c.Lookup(ip)
+		waitDNS(t, c)
+		assert.Equal(t, ip, c.Lookup(ip))
+	}
+	assert.LessOrEqual(t, len(c.entries), 2)
+}
+
+func TestGeoIPProcessorErrors(t *testing.T) {
+	for _, cfg := range []ProcessorConfig{
+		{Type: "geoip", Field: "ip"},
+		{Type: "geoip", Field: "ip", Database: "/nonexistent.mmdb"},
+		{Type: "geoip", Fields: []string{"a", "b"}, Target: "geo", ReverseDNS: true},
+		{Type: "geoip", Field: "ip", ReverseDNS: true, DNSCacheSize: -1},
+	} {
+		_, err := NewProcessor(cfg)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,62 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bytedance/sonic"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// JSONSchema validates payloads against a JSON Schema file
+type JSONSchema struct {
+	schema *jsonschema.Schema
+}
+
+// NewJSONSchema compiles the JSON Schema in path. References to other
+// local files are resolved relative to it.
+func NewJSONSchema(path string) (*JSONSchema, error) {
+	schema, err := jsonschema.Compile(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON schema %s: %w", path, err)
+	}
+	return &JSONSchema{schema: schema}, nil
+}
+
+// Validate checks evt and returns one message per violation, prefixed with
+// the JSON pointer of the offending value. An empty result means that the
+// event is valid.
+func (s *JSONSchema) Validate(evt *Event) []string {
+	var value interface{}
+	if data, err := evt.Data(); err == nil {
+		value = data
+	} else if err := sonic.Unmarshal(evt.raw, &value); err != nil {
+		return []string{err.Error()}
+	}
+
+	err := s.schema.Validate(value)
+	if err == nil {
+		return nil
+	}
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return []string{err.Error()}
+	}
+
+	var res []string
+	for _, unit := range ve.BasicOutput().Errors {
+		if unit.Error == "" || strings.HasPrefix(unit.Error, "doesn't validate with") {
+			continue
+		}
+		location := unit.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		res = append(res, location+": "+unit.Error)
+	}
+	if len(res) == 0 {
+		res = append(res, ve.Error())
+	}
+	return res
+}
@@ -1,20 +1,54 @@
 package common
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/bytedance/sonic"
+	"github.com/cespare/xxhash/v2"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
 	"github.com/twmb/franz-go/pkg/kgo"
 )
 
+// Supported key hash algorithms
+const (
+	KeyHashNone   = ""
+	KeyHashSHA256 = "sha256"
+	KeyHashXXHash = "xxhash"
+)
+
+// KafkaKeyConfig describes how the record key is built from a message.
+// Each field is a dotted path as accepted by StringToList; the values are
+// joined with Separator and optionally hashed. When any field is missing
+// the key falls back to Default, and a nil key is used if Default is empty.
+type KafkaKeyConfig struct {
+	Fields    []string
+	Separator string
+	Hash      string
+	Default   string
+}
+
 type KafkaProducer struct {
-	client   *kgo.Client
-	topic    string
-	keyField []string
-	keyFlag  bool
+	client     *kgo.Client
+	topic      string
+	keyFields  [][]string
+	keySep     string
+	keyHash    string
+	keyDefault string
+	keyFlag    bool
+
+	encoder    Encoder
+	encoding   string
+	errorTopic string
 }
 
 func StringToList(checkKey string) []string {
@@ -129,7 +163,15 @@ func GetCheckData(data map[string]interface{}, checkKeyList []string) (res strin
 	return res, exist
 }
 
-func NewKafkaProducer(brokers []string, topic string, keyField []string) (*KafkaProducer, error) {
+func NewKafkaProducer(brokers []string, topic string, keyConfig *KafkaKeyConfig) (*KafkaProducer, error) {
+	if keyConfig != nil {
+		switch keyConfig.Hash {
+		case KeyHashNone, KeyHashSHA256, KeyHashXXHash:
+		default:
+			return nil, fmt.Errorf("unsupported key hash: %s", keyConfig.Hash)
+		}
+	}
+
 	client, err := kgo.NewClient(
 		kgo.SeedBrokers(brokers...),
 	)
@@ -138,30 +180,79 @@ func NewKafkaProducer(brokers []string, topic string, keyField []string) (*Kafka
 	}
 
 	kp := &KafkaProducer{
-		client: client,
-		topic:  topic,
+		client:   client,
+		topic:    topic,
+		encoder:  jsonEncoder{},
+		encoding: EncodingJSON,
 	}
 
-	if len(keyField) > 0 {
+	if keyConfig != nil && len(keyConfig.Fields) > 0 {
 		kp.keyFlag = true
-		kp.keyField = keyField
+		for _, f := range keyConfig.Fields {
+			kp.keyFields = append(kp.keyFields, StringToList(f))
+		}
+		kp.keySep = keyConfig.Separator
+		kp.keyHash = keyConfig.Hash
+		kp.keyDefault = keyConfig.Default
 	}
 
 	return kp, nil
 }
 
-func (p *KafkaProducer) SendMessage(msg []byte) error {
+// buildKey joins the configured key fields found in data and applies the
+// configured hash. It returns nil when a field is missing and no default
+// key is configured.
+func (p *KafkaProducer) buildKey(data map[string]interface{}) []byte {
+	var sb strings.Builder
+	for i, field := range p.keyFields {
+		value, ok := GetCheckData(data, field)
+		if !ok {
+			if p.keyDefault == "" {
+				return nil
+			}
+			sb.Reset()
+			sb.WriteString(p.keyDefault)
+			break
+		}
+		if i > 0 {
+			sb.WriteString(p.keySep)
+		}
+		sb.WriteString(value)
+	}
+
+	switch p.keyHash {
+	case KeyHashSHA256:
+		sum := sha256.Sum256([]byte(sb.String()))
+		return []byte(hex.EncodeToString(sum[:]))
+	case KeyHashXXHash:
+		return []byte(strconv.FormatUint(xxhash.Sum64String(sb.String()), 16))
+	default:
+		return []byte(sb.String())
+	}
+}
+
+func (p *KafkaProducer) SendMessage(evt *Event) error {
+	if evt.topic != "" {
+		return p.sendRouted(evt)
+	}
+
 	var key []byte
 	if p.keyFlag {
-		// Parse JSON to get key field
-		var data map[string]interface{}
-		if err := sonic.Unmarshal(msg, &data); err != nil {
+		// Reuse the parsed event to get key fields
+		data, err := evt.Data()
+		if err != nil {
 			return fmt.Errorf("failed to parse message for key: %w", err)
 		}
+		key = p.buildKey(data)
+	}
 
-		if keyStr, ok := GetCheckData(data, p.keyField); ok {
-			key = []byte(keyStr)
+	msg, err := p.encoder.Encode(evt)
+	if err != nil {
+		AddMetric("kafka."+p.topic+".encode_errors", 1)
+		if p.errorTopic == "" {
+			return fmt.Errorf("failed to encode message as %s: %w", p.encoding, err)
 		}
+		return p.sendError(evt, key, err)
 	}
 
 	record := &kgo.Record{
@@ -177,7 +268,210 @@ func (p *KafkaProducer) SendMessage(msg []byte) error {
 	return nil
 }
 
+// sendError produces an event that could not be encoded to the error topic
+// as JSON, with the encoding error in the "error" header.
+func (p *KafkaProducer) sendError(evt *Event, key []byte, encodeErr error) error {
+	msg, err := evt.Bytes()
+	if err != nil {
+		return err
+	}
+	record := &kgo.Record{
+		Topic: p.errorTopic,
+		Key:   key,
+		Value: msg,
+		Headers: []kgo.RecordHeader{
+			{Key: "error", Value: []byte(encodeErr.Error())},
+			{Key: "encoding", Value: []byte(p.encoding)},
+			{Key: "topic", Value: []byte(p.topic)},
+		},
+	}
+	if err := p.client.ProduceSync(nil, record).FirstErr(); err != nil {
+		return fmt.Errorf("failed to produce message to error topic: %w", err)
+	}
+	return nil
+}
+
+// sendRouted produces an event routed with SetTopic without encoding it.
+// The key is left empty when the event is not a JSON object.
+func (p *KafkaProducer) sendRouted(evt *Event) error {
+	var key []byte
+	if data, err := evt.Data(); p.keyFlag && err == nil {
+		key = p.buildKey(data)
+	}
+	msg, err := evt.Bytes()
+	if err != nil {
+		return err
+	}
+	record := &kgo.Record{
+		Topic: evt.topic,
+		Key:   key,
+		Value: msg,
+	}
+	for k, v := range evt.headers {
+		record.Headers = append(record.Headers, kgo.RecordHeader{Key: k, Value: []byte(v)})
+	}
+	if err := p.client.ProduceSync(nil, record).FirstErr(); err != nil {
+		return fmt.Errorf("failed to produce message to %s: %w", evt.topic, err)
+	}
+	return nil
+}
+
+// SetEncoder sets the serialization of record values. name is the encoding
+// reported in errors and error topic headers.
+func (p *KafkaProducer) SetEncoder(name string, encoder Encoder) {
+	p.encoding = name
+	p.encoder = encoder
+}
+
+// SetErrorTopic sets the topic receiving events that fail to encode. Without
+// it such events are dropped and SendMessage returns the error.
+func (p *KafkaProducer) SetErrorTopic(topic string) {
+	p.errorTopic = topic
+}
+
 func (p *KafkaProducer) Close() error {
+	if closer, ok := p.encoder.(io.Closer); ok {
+		closer.Close()
+	}
 	p.client.Close()
 	return nil
 }
+
+// TopicConfig describes how missing topics are created at startup, and what
+// existing topics are expected to look like
+type TopicConfig struct {
+	// Partitions and ReplicationFactor default to the broker defaults
+	Partitions        int32             `yaml:"partitions,omitempty"`
+	ReplicationFactor int16             `yaml:"replication_factor,omitempty"`
+	Configs           map[string]string `yaml:"configs,omitempty"`
+	// Strict fails when an existing topic differs instead of logging it
+	Strict bool `yaml:"strict,omitempty"`
+}
+
+// EnsureTopics checks that topics exist, creating missing ones as described
+// by create. Existing topics are compared with the partitions, replication
+// factor and configs set in create. A nil create only checks that topics
+// exist.
+func (p *KafkaProducer) EnsureTopics(ctx context.Context, create *TopicConfig, topics ...string) error {
+	adm := kadm.NewClient(p.client)
+	details, err := adm.ListTopics(ctx, topics...)
+	if err != nil {
+		return fmt.Errorf("failed to list topics: %w", err)
+	}
+
+	var missing, existing []string
+	for _, topic := range topics {
+		detail, ok := details[topic]
+		switch {
+		case !ok || errors.Is(detail.Err, kerr.UnknownTopicOrPartition):
+			missing = append(missing, topic)
+		case detail.Err != nil:
+			return fmt.Errorf("failed to describe topic %s: %w", topic, detail.Err)
+		default:
+			existing = append(existing, topic)
+		}
+	}
+	if create != nil && len(existing) > 0 {
+		if err := checkTopics(ctx, adm, create, details, existing); err != nil {
+			return err
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if create == nil {
+		return fmt.Errorf("missing topics: %s", strings.Join(missing, ", "))
+	}
+
+	partitions, replication := create.Partitions, create.ReplicationFactor
+	if partitions <= 0 {
+		partitions = -1
+	}
+	if replication <= 0 {
+		replication = -1
+	}
+	configs := make(map[string]*string, len(create.Configs))
+	for k, v := range create.Configs {
+		configs[k] = kadm.StringPtr(v)
+	}
+
+	resp, err := adm.CreateTopics(ctx, partitions, replication, configs, missing...)
+	if err != nil {
+		return fmt.Errorf("failed to create topics: %w", err)
+	}
+	for _, topic := range missing {
+		res, ok := resp[topic]
+		if !ok {
+			return fmt.Errorf("failed to create topic %s: no response", topic)
+		}
+		if res.Err != nil && !errors.Is(res.Err, kerr.TopicAlreadyExists) {
+			msg := res.Err.Error()
+			if res.ErrMessage != "" {
+				msg += ": " + res.ErrMessage
+			}
+			return fmt.Errorf("failed to create topic %s: %s", topic, msg)
+		}
+	}
+	return nil
+}
+
+// checkTopics compares existing topics with want. Differences are logged,
+// or returned as an error when want.Strict is set.
+func checkTopics(ctx context.Context, adm *kadm.Client, want *TopicConfig, details kadm.TopicDetails, topics []string) error {
+	var configs kadm.ResourceConfigs
+	if len(want.Configs) > 0 {
+		var err error
+		if configs, err = adm.DescribeTopicConfigs(ctx, topics...); err != nil {
+			return fmt.Errorf("failed to describe topic configs: %w", err)
+		}
+	}
+
+	var diffs []string
+	for _, topic := range topics {
+		partitions := details[topic].Partitions
+		if want.Partitions > 0 && len(partitions) != int(want.Partitions) {
+			diffs = append(diffs, fmt.Sprintf("%s has %d partitions, want %d", topic, len(partitions), want.Partitions))
+		}
+		if want.ReplicationFactor > 0 && partitions.NumReplicas() != int(want.ReplicationFactor) {
+			diffs = append(diffs, fmt.Sprintf("%s has replication factor %d, want %d", topic, partitions.NumReplicas(), want.ReplicationFactor))
+		}
+		if len(want.Configs) == 0 {
+			continue
+		}
+
+		rc, err := configs.On(topic, nil)
+		if err == nil {
+			err = rc.Err
+		}
+		if err != nil {
+			return fmt.Errorf("failed to describe configs of topic %s: %w", topic, err)
+		}
+		current := make(map[string]string, len(rc.Configs))
+		for _, c := range rc.Configs {
+			if c.Value != nil {
+				current[c.Key] = *c.Value
+			}
+		}
+		keys := make([]string, 0, len(want.Configs))
+		for k := range want.Configs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if v, ok := current[k]; !ok || v != want.Configs[k] {
+				diffs = append(diffs, fmt.Sprintf("%s has %s=%q, want %q", topic, k, v, want.Configs[k]))
+			}
+		}
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+	if want.Strict {
+		return fmt.Errorf("topics differ from create_topic: %s", strings.Join(diffs, "; "))
+	}
+	log := ComponentLogger("kafka")
+	for _, diff := range diffs {
+		log.Warn("Topic differs from create_topic", "difference", diff)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kfake"
+)
+
+func TestBuildKey(t *testing.T) {
+	data := map[string]interface{}{
+		"host":  "web-1",
+		"level": float64(3),
+		"user":  map[string]interface{}{"id": "42"},
+		"a.b":   "dotted",
+	}
+	sha := sha256.Sum256([]byte("web-1|42"))
+
+	tests := []struct {
+		name string
+		cfg  KafkaKeyConfig
+		want []byte
+	}{
+		{
+			name: "single field",
+			cfg:  KafkaKeyConfig{Fields: []string{"host"}},
+			want: []byte("web-1"),
+		},
+		{
+			name: "composite",
+			cfg:  KafkaKeyConfig{Fields: []string{"host", "user.id", "level"}, Separator: "|"},
+			want: []byte("web-1|42|3"),
+		},
+		{
+			name: "escaped dot",
+			cfg:  KafkaKeyConfig{Fields: []string{`a\.b`}},
+			want: []byte("dotted"),
+		},
+		{
+			name: "missing field without default",
+			cfg:  KafkaKeyConfig{Fields: []string{"host", "missing"}, Separator: "|"},
+			want: nil,
+		},
+		{
+			name: "missing field with default",
+			cfg:  KafkaKeyConfig{Fields: []string{"host", "missing"}, Separator: "|", Default: "none"},
+			want: []byte("none"),
+		},
+		{
+			name: "sha256",
+			cfg:  KafkaKeyConfig{Fields: []string{"host", "user.id"}, Separator: "|", Hash: KeyHashSHA256},
+			want: []byte(hex.EncodeToString(sha[:])),
+		},
+		{
+			name: "xxhash",
+			cfg:  KafkaKeyConfig{Fields: []string{"host", "user.id"}, Separator: "|", Hash: KeyHashXXHash},
+			want: []byte(strconv.FormatUint(xxhash.Sum64String("web-1|42"), 16)),
+		},
+		{
+			name: "default is hashed",
+			cfg:  KafkaKeyConfig{Fields: []string{"missing"}, Hash: KeyHashXXHash, Default: "none"},
+			want: []byte(strconv.FormatUint(xxhash.Sum64String("none"), 16)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewKafkaProducer([]string{"localhost:9092"}, "test", &tt.cfg)
+			require.NoError(t, err)
+			defer p.Close()
+			assert.Equal(t, tt.want, p.buildKey(data))
+		})
+	}
+}
+
+func TestNewKafkaProducerKeyHash(t *testing.T) {
+	_, err := NewKafkaProducer([]string{"localhost:9092"}, "test", &KafkaKeyConfig{Fields: []string{"host"}, Hash: "md5"})
+	assert.EqualError(t, err, "unsupported key hash: md5")
+}
+
+func TestEnsureTopics(t *testing.T) {
+	tests := []struct {
+		name   string
+		create *TopicConfig
+		topics []string
+		err    string
+	}{
+		{name: "existing topic", topics: []string{"existing"}},
+		{name: "missing topic", topics: []string{"existing", "missing"}, err: "missing topics: missing"},
+		{name: "missing topic created", create: &TopicConfig{Partitions: 3}, topics: []string{"missing"}},
+		{
+			name:   "matching topic",
+			create: &TopicConfig{Partitions: 2, ReplicationFactor: 1, Configs: map[string]string{"retention.ms": "3600000"}, Strict: true},
+			topics: []string{"existing"},
+		},
+		{
+			name:   "differences logged",
+			create: &TopicConfig{Partitions: 4, Configs: map[string]string{"compression.type": "zstd"}},
+			topics: []string{"existing"},
+		},
+		{
+			name:   "strict partitions",
+			create: &TopicConfig{Partitions: 4, Strict: true},
+			topics: []string{"existing"},
+			err:    "existing has 2 partitions, want 4",
+		},
+		{
+			name:   "strict configs",
+			create: &TopicConfig{Configs: map[string]string{"retention.ms": "60000", "compression.type": "zstd"}, Strict: true},
+			topics: []string{"existing"},
+			err:    `existing has compression.type="producer", want "zstd"; existing has retention.ms="3600000", want "60000"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, err := kfake.NewCluster(kfake.NumBrokers(1))
+			require.NoError(t, err)
+			defer cluster.Close()
+
+			p, err := NewKafkaProducer(cluster.ListenAddrs(), "existing", nil)
+			require.NoError(t, err)
+			defer p.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err = kadm.NewClient(p.client).CreateTopic(ctx, 2, 1, map[string]*string{"retention.ms": kadm.StringPtr("3600000")}, "existing")
+			require.NoError(t, err)
+
+			err = p.EnsureTopics(ctx, tt.create, tt.topics...)
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			details, err := kadm.NewClient(p.client).ListTopics(ctx, tt.topics...)
+			require.NoError(t, err)
+			assert.NoError(t, details.Error())
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// errorLogBurst error records with the same message are written per
+	// errorLogInterval before further ones are suppressed
+	errorLogBurst    = 10
+	errorLogInterval = 10 * time.Second
+)
+
+// LogConfig represents the logging configuration
+type LogConfig struct {
+	Level  string `yaml:"level,omitempty"`
+	Format string `yaml:"format,omitempty"`
+}
+
+var logLevel = new(slog.LevelVar)
+
+// SetupLogging installs the default logger described by cfg. Error records
+// are rate limited per logger and message so that an outage does not flood
+// the output.
+func SetupLogging(cfg LogConfig, w io.Writer) error {
+	if err := SetLogLevel(cfg.Level); err != nil {
+		return err
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	switch cfg.Format {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unsupported log format: %s", cfg.Format)
+	}
+
+	slog.SetDefault(slog.New(&rateLimitedHandler{
+		Handler: handler,
+		state:   &errorLogState{windows: make(map[string]*errorLogWindow)},
+	}))
+	return nil
+}
+
+// SetLogLevel changes the level of the default logger at runtime.
+func SetLogLevel(level string) error {
+	if level == "" {
+		level = "info"
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level: %s", level)
+	}
+	logLevel.Set(l)
+	return nil
+}
+
+// ComponentLogger returns a logger tagged with the component name and the
+// given attributes, such as a kafka id or listen address.
+func ComponentLogger(component string, args ...any) *slog.Logger {
+	return slog.Default().With(append([]any{"component", component}, args...)...)
+}
+
+// LogLevelHandler serves the current log level on GET and changes it on PUT
+// or POST, taking the level from the "level" query parameter or the body.
+func LogLevelHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			level := req.URL.Query().Get("level")
+			if level == "" {
+				body, _ := io.ReadAll(io.LimitReader(req.Body, 64))
+				level = strings.TrimSpace(string(body))
+			}
+			if err := SetLogLevel(level); err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			slog.Info("Log level changed", "level", logLevel.Level().String())
+		default:
+			http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rw.Write([]byte(logLevel.Level().String() + "\n"))
+	})
+}
+
+// errorLogWindow counts error records with one message in the current interval.
+type errorLogWindow struct {
+	start      time.Time
+	count      int
+	suppressed int
+}
+
+type errorLogState struct {
+	mu      sync.Mutex
+	windows map[string]*errorLogWindow
+}
+
+// rateLimitedHandler writes at most errorLogBurst error records per message
+// and interval. The number of suppressed records is reported on the first
+// record of the next interval. Records are counted per logger, keyed by its
+// attributes such as the component and destination, so that an error of
+// one destination does not hide the same error of another.
+type rateLimitedHandler struct {
+	slog.Handler
+	state *errorLogState
+	// scope holds the attributes of the logger
+	scope string
+}
+
+func (h *rateLimitedHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelError {
+		return h.Handler.Handle(ctx, r)
+	}
+
+	key := h.scope
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == "component" || a.Key == "destination" {
+			key += a.String() + " "
+		}
+		return true
+	})
+	key += r.Message
+
+	h.state.mu.Lock()
+	w, ok := h.state.windows[key]
+	if !ok {
+		if len(h.state.windows) >= maxLimiterKeys {
+			h.state.windows = make(map[string]*errorLogWindow)
+		}
+		w = &errorLogWindow{start: r.Time}
+		h.state.windows[key] = w
+	}
+	if r.Time.Sub(w.start) >= errorLogInterval {
+		if w.suppressed > 0 {
+			r.AddAttrs(slog.Int("suppressed", w.suppressed))
+		}
+		w.start, w.count, w.suppressed = r.Time, 0, 0
+	}
+	w.count++
+	if w.count > errorLogBurst {
+		w.suppressed++
+		h.state.mu.Unlock()
+		return nil
+	}
+	h.state.mu.Unlock()
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *rateLimitedHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	scope := h.scope
+	for _, a := range attrs {
+		scope += a.String() + " "
+	}
+	return &rateLimitedHandler{Handler: h.Handler.WithAttrs(attrs), state: h.state, scope: scope}
+}
+
+func (h *rateLimitedHandler) WithGroup(name string) slog.Handler {
+	return &rateLimitedHandler{Handler: h.Handler.WithGroup(name), state: h.state, scope: h.scope + name + "."}
+}
@@ -0,0 +1,110 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(&rateLimitedHandler{
+		Handler: slog.NewTextHandler(buf, nil),
+		state:   &errorLogState{windows: make(map[string]*errorLogWindow)},
+	})
+}
+
+func TestRateLimitedHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		log  func(*slog.Logger)
+		want map[string]int
+	}{
+		{
+			name: "same logger",
+			log: func(l *slog.Logger) {
+				a := l.With("component", "sink", "destination", "a")
+				for i := 0; i < 15; i++ {
+					a.Error("Error sending")
+				}
+			},
+			want: map[string]int{"destination=a": errorLogBurst},
+		},
+		{
+			name: "destinations counted apart",
+			log: func(l *slog.Logger) {
+				a := l.With("component", "sink", "destination", "a")
+				b := l.With("component", "sink", "destination", "b")
+				for i := 0; i < 15; i++ {
+					a.Error("Error sending")
+					b.Error("Error sending")
+				}
+			},
+			want: map[string]int{"destination=a": errorLogBurst, "destination=b": errorLogBurst},
+		},
+		{
+			name: "destination in the record",
+			log: func(l *slog.Logger) {
+				for i := 0; i < 15; i++ {
+					l.Error("Error copying event", "destination", "a", "n", i)
+					l.Error("Error copying event", "destination", "b", "n", i)
+				}
+			},
+			want: map[string]int{"destination=a": errorLogBurst, "destination=b": errorLogBurst},
+		},
+		{
+			name: "other attributes share the limit",
+			log: func(l *slog.Logger) {
+				for i := 0; i < 15; i++ {
+					l.Error("Error sending", "destination", "a", "error", i)
+				}
+			},
+			want: map[string]int{"destination=a": errorLogBurst},
+		},
+		{
+			name: "warnings not limited",
+			log: func(l *slog.Logger) {
+				for i := 0; i < 15; i++ {
+					l.Warn("Slow", "destination", "a")
+				}
+			},
+			want: map[string]int{"destination=a": 15},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.log(newTestLogger(&buf))
+			got := make(map[string]int)
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				for want := range tt.want {
+					if strings.Contains(line, want) {
+						got[want]++
+					}
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRateLimitedHandlerReportsSuppressed(t *testing.T) {
+	var buf bytes.Buffer
+	handler := newTestLogger(&buf).Handler()
+	start := time.Now()
+	for i := 0; i < errorLogBurst+3; i++ {
+		assert.NoError(t, handler.Handle(context.Background(), slog.NewRecord(start, slog.LevelError, "Error sending", 0)))
+	}
+	assert.NotContains(t, buf.String(), "suppressed")
+
+	next := slog.NewRecord(start.Add(errorLogInterval), slog.LevelError, "Error sending", 0)
+	assert.NoError(t, handler.Handle(context.Background(), next))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, errorLogBurst+1)
+	assert.Contains(t, lines[len(lines)-1], "suppressed=3")
+}
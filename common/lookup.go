@@ -0,0 +1,315 @@
+package common
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+const defaultLookupReloadInterval = 30 * time.Second
+
+// lookupTable maps keys to rows. Keys that are CIDR blocks match any
+// address they contain, the most specific block winning.
+type lookupTable struct {
+	rows  map[string]map[string]interface{}
+	cidrs []lookupCIDR
+}
+
+type lookupCIDR struct {
+	net *net.IPNet
+	row map[string]interface{}
+}
+
+// Get returns the row for key. Addresses in host:port form are also looked
+// up by host.
+func (t *lookupTable) Get(key string) (map[string]interface{}, bool) {
+	if row, ok := t.rows[key]; ok {
+		return row, true
+	}
+	host := clientIP(key)
+	if row, ok := t.rows[host]; ok {
+		return row, true
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, c := range t.cidrs {
+			if c.net.Contains(ip) {
+				return c.row, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func newLookupTable(rows map[string]map[string]interface{}) *lookupTable {
+	t := &lookupTable{rows: make(map[string]map[string]interface{}, len(rows))}
+	for key, row := range rows {
+		if strings.Contains(key, "/") {
+			if _, ipNet, err := net.ParseCIDR(key); err == nil {
+				t.cidrs = append(t.cidrs, lookupCIDR{net: ipNet, row: row})
+				continue
+			}
+		}
+		t.rows[key] = row
+	}
+	sort.Slice(t.cidrs, func(i, j int) bool {
+		bi, _ := t.cidrs[i].net.Mask.Size()
+		bj, _ := t.cidrs[j].net.Mask.Size()
+		return bi > bj
+	})
+	return t
+}
+
+// loadLookupTable reads a CSV file with a header row, or a JSON file holding
+// either an object of rows by key or a list of rows. keyColumn names the
+// key of each row and defaults to the first CSV column.
+func loadLookupTable(path, keyColumn string) (*lookupTable, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make(map[string]map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return newLookupTable(rows), nil
+		}
+		header, keyIndex := records[0], 0
+		if keyColumn != "" {
+			keyIndex = -1
+			for i, name := range header {
+				if name == keyColumn {
+					keyIndex = i
+				}
+			}
+			if keyIndex < 0 {
+				return nil, fmt.Errorf("key column %s not found", keyColumn)
+			}
+		}
+		for _, record := range records[1:] {
+			row := make(map[string]interface{}, len(header)-1)
+			for i, value := range record {
+				if i != keyIndex && i < len(header) {
+					row[header[i]] = value
+				}
+			}
+			rows[record[keyIndex]] = row
+		}
+	case ".json":
+		var value interface{}
+		if err := sonic.Unmarshal(content, &value); err != nil {
+			return nil, err
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key, item := range v {
+				row, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("row %s is not an object", key)
+				}
+				rows[key] = row
+			}
+		case []interface{}:
+			if keyColumn == "" {
+				return nil, fmt.Errorf("key is required for a list of rows")
+			}
+			for i, item := range v {
+				row, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("row %d is not an object", i)
+				}
+				key, ok := row[keyColumn]
+				if !ok {
+					return nil, fmt.Errorf("row %d has no %s", i, keyColumn)
+				}
+				delete(row, keyColumn)
+				rows[AnyToString(key)] = row
+			}
+		default:
+			return nil, fmt.Errorf("lookup table must be an object or a list")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported lookup file type: %s", path)
+	}
+	return newLookupTable(rows), nil
+}
+
+// lookupFile holds a lookup table. A background goroutine checks the file's
+// size and modification time every interval and swaps in the reloaded table
+// when they changed, so events never wait for a reload. A failed reload
+// keeps the previous table.
+type lookupFile struct {
+	path      string
+	keyColumn string
+	interval  time.Duration
+	log       *slog.Logger
+	stop      chan struct{}
+	stopOnce  sync.Once
+
+	table   atomic.Pointer[lookupTable]
+	modTime time.Time
+	size    int64
+}
+
+func newLookupFile(path, keyColumn string, interval time.Duration) (*lookupFile, error) {
+	if interval <= 0 {
+		interval = defaultLookupReloadInterval
+	}
+	f := &lookupFile{
+		path:      path,
+		keyColumn: keyColumn,
+		interval:  interval,
+		log:       ComponentLogger("lookup", "file", path),
+		stop:      make(chan struct{}),
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	table, err := loadLookupTable(path, keyColumn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	f.table.Store(table)
+	f.modTime, f.size = info.ModTime(), info.Size()
+
+	// The watcher runs until the processor is closed
+	go f.watch()
+	return f, nil
+}
+
+// Table returns the current table.
+func (f *lookupFile) Table() *lookupTable {
+	return f.table.Load()
+}
+
+func (f *lookupFile) watch() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.reload()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// Stop stops the watcher. It is safe to call more than once.
+func (f *lookupFile) Stop() {
+	f.stopOnce.Do(func() { close(f.stop) })
+}
+
+func (f *lookupFile) reload() {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		f.log.Error("Error checking lookup table", "error", err)
+		return
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return
+	}
+	table, err := loadLookupTable(f.path, f.keyColumn)
+	if err != nil {
+		AddMetric("lookup.reload_errors", 1)
+		f.log.Error("Error reloading lookup table", "error", err)
+		return
+	}
+	f.table.Store(table)
+	f.modTime, f.size = info.ModTime(), info.Size()
+	AddMetric("lookup.reloads", 1)
+	f.log.Info("Reloaded lookup table", "keys", len(table.rows)+len(table.cidrs))
+}
+
+// lookupProcessor merges the row matching the value of field into target,
+// or into the event itself when no target is given. Existing fields are
+// kept unless overwrite is set.
+type lookupProcessor struct {
+	mapProcessor
+	file *lookupFile
+}
+
+func newLookupProcessor(cfg ProcessorConfig) (*lookupProcessor, error) {
+	if cfg.Field == "" {
+		return nil, fmt.Errorf("field is required")
+	}
+	if cfg.File == "" {
+		return nil, fmt.Errorf("file is required")
+	}
+	file, err := newLookupFile(cfg.File, cfg.Key, cfg.ReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+	field := StringToList(cfg.Field)
+	var target []string
+	if cfg.Target != "" {
+		target = StringToList(cfg.Target)
+	}
+
+	p := &lookupProcessor{file: file}
+	p.mapProcessor = func(data map[string]interface{}) (bool, error) {
+		value, ok := GetField(data, field)
+		if !ok {
+			return false, nil
+		}
+		row, ok := file.Table().Get(AnyToString(value))
+		if !ok {
+			AddMetric("lookup.misses", 1)
+			return false, nil
+		}
+		changed := false
+		for name, v := range row {
+			path := append(append([]string{}, target...), name)
+			if _, exists := GetField(data, path); exists && !cfg.Overwrite {
+				continue
+			}
+			// Rows are shared by all events, which processors may modify
+			SetField(data, path, copyValue(v))
+			changed = true
+		}
+		return changed, nil
+	}
+	return p, nil
+}
+
+// Close stops reloading the lookup table.
+func (p *lookupProcessor) Close() error {
+	p.file.Stop()
+	return nil
+}
+
+// copyValue returns a deep copy of a JSON value.
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			c[k] = copyValue(item)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, item := range v {
+			c[i] = copyValue(item)
+		}
+		return c
+	default:
+		return v
+	}
+}
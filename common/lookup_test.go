@@ -0,0 +1,160 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeLookupFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLookupTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		key     string
+		lookup  string
+		want    map[string]interface{}
+	}{
+		{
+			name:    "csv first column",
+			file:    "hosts.csv",
+			content: "host,owner,env\nweb1,alice,prod\n",
+			lookup:  "web1",
+			want:    map[string]interface{}{"owner": "alice", "env": "prod"},
+		},
+		{
+			name:    "csv key column",
+			file:    "hosts.csv",
+			content: "owner,host\nalice,web1\n",
+			key:     "host",
+			lookup:  "web1",
+			want:    map[string]interface{}{"owner": "alice"},
+		},
+		{
+			name:    "json object",
+			file:    "hosts.json",
+			content: `{"web1":{"owner":"alice"}}`,
+			lookup:  "web1",
+			want:    map[string]interface{}{"owner": "alice"},
+		},
+		{
+			name:    "json list",
+			file:    "hosts.json",
+			content: `[{"host":"web1","owner":"alice"}]`,
+			key:     "host",
+			lookup:  "web1",
+			want:    map[string]interface{}{"owner": "alice"},
+		},
+		{
+			name:    "most specific cidr",
+			file:    "nets.csv",
+			content: "net,site\n10.0.0.0/8,corp\n10.1.0.0/16,lab\n",
+			lookup:  "10.1.2.3:514",
+			want:    map[string]interface{}{"site": "lab"},
+		},
+		{
+			name:    "miss",
+			file:    "nets.csv",
+			content: "net,site\n10.0.0.0/8,corp\n",
+			lookup:  "192.0.2.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := loadLookupTable(writeLookupFile(t, tt.file, tt.content), tt.key)
+			require.NoError(t, err)
+			row, ok := table.Get(tt.lookup)
+			assert.Equal(t, tt.want != nil, ok)
+			if tt.want != nil {
+				assert.Equal(t, tt.want, row)
+			}
+		})
+	}
+}
+
+func TestLookupTableErrors(t *testing.T) {
+	tests := []struct {
+		file    string
+		content string
+		key     string
+	}{
+		{"hosts.csv", "host,owner\n", "missing"},
+		{"hosts.json", `{"web1":"alice"}`, ""},
+		{"hosts.json", `[{"host":"web1"}]`, ""},
+		{"hosts.json", `"nope"`, ""},
+		{"hosts.txt", "", ""},
+	}
+	for _, tt := range tests {
+		_, err := loadLookupTable(writeLookupFile(t, tt.file, tt.content), tt.key)
+		assert.Error(t, err, tt.content)
+	}
+}
+
+func TestLookupProcessorCopiesRows(t *testing.T) {
+	path := writeLookupFile(t, "hosts.json", `{"web1":{"tags":["prod"],"owner":{"name":"alice"}}}`)
+	processor, err := NewProcessor(ProcessorConfig{Type: "lookup", Field: "host", File: path, Target: "info"})
+	require.NoError(t, err)
+
+	events, err := processor.Process(NewEventFromMap(map[string]interface{}{"host": "web1"}))
+	require.NoError(t, err)
+	data, err := events[0].Data()
+	require.NoError(t, err)
+	info := data["info"].(map[string]interface{})
+	info["owner"].(map[string]interface{})["name"] = "mallory"
+	info["tags"].([]interface{})[0] = "dev"
+
+	events, err = processor.Process(NewEventFromMap(map[string]interface{}{"host": "web1"}))
+	require.NoError(t, err)
+	raw, err := events[0].Bytes()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"host":"web1","info":{"tags":["prod"],"owner":{"name":"alice"}}}`, string(raw))
+}
+
+func TestLookupFileReload(t *testing.T) {
+	path := writeLookupFile(t, "hosts.csv", "host,owner\nweb1,alice\n")
+	f, err := newLookupFile(path, "", 10*time.Millisecond)
+	require.NoError(t, err)
+	t.Cleanup(f.Stop)
+	row, _ := f.Table().Get("web1")
+	assert.Equal(t, "alice", row["owner"])
+
+	// A broken file keeps the previous table
+	require.NoError(t, os.WriteFile(path, []byte("host,owner\n\"web1,bob\n"), 0o600))
+	time.Sleep(50 * time.Millisecond)
+	row, _ = f.Table().Get("web1")
+	assert.Equal(t, "alice", row["owner"])
+
+	require.NoError(t, os.WriteFile(path, []byte("host,owner\nweb1,carol\n"), 0o600))
+	assert.Eventually(t, func() bool {
+		row, _ := f.Table().Get("web1")
+		return row["owner"] == "carol"
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestLookupProcessorClose(t *testing.T) {
+	path := writeLookupFile(t, "hosts.csv", "host,owner\nweb1,alice\n")
+	processor, err := NewProcessor(ProcessorConfig{Type: "lookup", Field: "host", File: path, ReloadInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	pipeline := &Pipeline{processors: []Processor{processor}}
+	require.NoError(t, pipeline.Close())
+	require.NoError(t, pipeline.Close())
+
+	// The table is no longer reloaded
+	file := processor.(*lookupProcessor).file
+	require.NoError(t, os.WriteFile(path, []byte("host,owner\nweb1,carol\n"), 0o600))
+	time.Sleep(50 * time.Millisecond)
+	row, _ := file.Table().Get("web1")
+	assert.Equal(t, "alice", row["owner"])
+}
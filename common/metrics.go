@@ -0,0 +1,33 @@
+package common
+
+import (
+	"expvar"
+	"strings"
+)
+
+// Metrics holds the counters exported by the service. Keys are dotted
+// names such as "script.enrich.calls" and are served by the admin server
+// under /debug/vars.
+var Metrics = expvar.NewMap("syslog_webhook_to_kafka")
+
+// AddMetric adds delta to the counter identified by name.
+func AddMetric(name string, delta int64) {
+	Metrics.Add(name, delta)
+}
+
+// metricKey turns a listen address or URL into a single metric name
+// segment: the scheme is removed and characters other than letters, digits,
+// '-' and '_' become '_', so "0.0.0.0:514" is reported as "0_0_0_0_514".
+func metricKey(s string) string {
+	if i := strings.Index(s, "://"); i >= 0 {
+		s = s[i+3:]
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
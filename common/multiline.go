@@ -0,0 +1,134 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+const (
+	defaultMultilineMaxLines = 500
+	defaultMultilineMaxBytes = 64 * 1024
+	defaultMultilineTimeout  = time.Second
+)
+
+// MultilineConfig represents the rules joining consecutive syslog messages
+// of one sender, such as the lines of a stack trace, into one event
+type MultilineConfig struct {
+	// Start matches the first line of an event; other lines continue it
+	Start string `yaml:"start,omitempty"`
+	// Continue matches lines that continue the previous event. With both
+	// patterns, lines matching neither start a new event.
+	Continue string        `yaml:"continue,omitempty"`
+	MaxLines int           `yaml:"max_lines,omitempty"`
+	MaxBytes int           `yaml:"max_bytes,omitempty"`
+	Timeout  time.Duration `yaml:"timeout,omitempty"`
+}
+
+type multilineEvent struct {
+	msg   *SyslogMessage
+	lines int
+	last  time.Time
+}
+
+// multilineAggregator joins messages with the same hostname, app_name and
+// proc_id. It is not safe for concurrent use.
+type multilineAggregator struct {
+	start    *regexp.Regexp
+	cont     *regexp.Regexp
+	maxLines int
+	maxBytes int
+	timeout  time.Duration
+	emit     func(*SyslogMessage)
+	pending  map[string]*multilineEvent
+}
+
+func newMultilineAggregator(cfg *MultilineConfig, emit func(*SyslogMessage)) (*multilineAggregator, error) {
+	if cfg.Start == "" && cfg.Continue == "" {
+		return nil, fmt.Errorf("multiline start or continue pattern is required")
+	}
+	a := &multilineAggregator{
+		maxLines: cfg.MaxLines,
+		maxBytes: cfg.MaxBytes,
+		timeout:  cfg.Timeout,
+		emit:     emit,
+		pending:  make(map[string]*multilineEvent),
+	}
+	var err error
+	if cfg.Start != "" {
+		if a.start, err = regexp.Compile(cfg.Start); err != nil {
+			return nil, fmt.Errorf("invalid multiline start pattern: %w", err)
+		}
+	}
+	if cfg.Continue != "" {
+		if a.cont, err = regexp.Compile(cfg.Continue); err != nil {
+			return nil, fmt.Errorf("invalid multiline continue pattern: %w", err)
+		}
+	}
+	if a.maxLines <= 0 {
+		a.maxLines = defaultMultilineMaxLines
+	}
+	if a.maxBytes <= 0 {
+		a.maxBytes = defaultMultilineMaxBytes
+	}
+	if a.timeout <= 0 {
+		a.timeout = defaultMultilineTimeout
+	}
+	return a, nil
+}
+
+// continues reports whether content continues the previous event. When both
+// patterns are set, only lines matching continue and not start do.
+func (a *multilineAggregator) continues(content string) bool {
+	if a.start != nil && a.start.MatchString(content) {
+		return false
+	}
+	if a.cont != nil {
+		return a.cont.MatchString(content)
+	}
+	return true
+}
+
+// Add appends msg to the pending event of its sender or starts a new one,
+// emitting the previous event when it is complete or full.
+func (a *multilineAggregator) Add(msg *SyslogMessage, now time.Time) {
+	key := msg.Hostname + "\x00" + msg.AppName + "\x00" + msg.ProcID
+	e, ok := a.pending[key]
+	if ok && a.continues(msg.Content) && e.lines < a.maxLines &&
+		len(e.msg.Content)+1+len(msg.Content) <= a.maxBytes {
+		e.msg.Content += "\n" + msg.Content
+		e.lines++
+		e.last = now
+		return
+	}
+	if ok {
+		a.emit(e.msg)
+	}
+	a.pending[key] = &multilineEvent{msg: msg, lines: 1, last: now}
+}
+
+// Flush emits the events that received no line within the timeout.
+func (a *multilineAggregator) Flush(now time.Time) {
+	for key, e := range a.pending {
+		if now.Sub(e.last) >= a.timeout {
+			a.emit(e.msg)
+			delete(a.pending, key)
+		}
+	}
+}
+
+// FlushAll emits all pending events.
+func (a *multilineAggregator) FlushAll() {
+	for key, e := range a.pending {
+		a.emit(e.msg)
+		delete(a.pending, key)
+	}
+}
+
+// FlushInterval returns how often Flush should be called.
+func (a *multilineAggregator) FlushInterval() time.Duration {
+	if interval := a.timeout / 2; interval > 10*time.Millisecond {
+		return interval
+	}
+	return 10 * time.Millisecond
+}
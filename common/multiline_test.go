@@ -0,0 +1,112 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+func TestMultilineAggregator(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   MultilineConfig
+		lines []string
+		want  []string
+	}{
+		{
+			name:  "start",
+			cfg:   MultilineConfig{Start: `^\d{4}-`},
+			lines: []string{"2024-05-01 error", "\tat a", "\tat b", "2024-05-01 next"},
+			want:  []string{"2024-05-01 error\n\tat a\n\tat b", "2024-05-01 next"},
+		},
+		{
+			name:  "continue",
+			cfg:   MultilineConfig{Continue: `^\s`},
+			lines: []string{"Exception", "\tat a", "Caused by", " at b"},
+			want:  []string{"Exception\n\tat a", "Caused by\n at b"},
+		},
+		{
+			name:  "both",
+			cfg:   MultilineConfig{Start: `^\d{4}-`, Continue: `^\s`},
+			lines: []string{"2024-05-01 error", "\tat a", "unrelated", "\tat b", "2024-05-01 next"},
+			want:  []string{"2024-05-01 error\n\tat a", "unrelated\n\tat b", "2024-05-01 next"},
+		},
+		{
+			name:  "max lines",
+			cfg:   MultilineConfig{Continue: `^\s`, MaxLines: 2},
+			lines: []string{"a", " 1", " 2", " 3"},
+			want:  []string{"a\n 1", " 2\n 3"},
+		},
+		{
+			name:  "max bytes",
+			cfg:   MultilineConfig{Continue: `^\s`, MaxBytes: 6},
+			lines: []string{"abc", " 1", " 2"},
+			want:  []string{"abc\n 1", " 2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			a, err := newMultilineAggregator(&tt.cfg, func(m *SyslogMessage) { got = append(got, m.Content) })
+			require.NoError(t, err)
+			now := time.Now()
+			for _, line := range tt.lines {
+				a.Add(&SyslogMessage{Hostname: "h", AppName: "app", Content: line}, now)
+			}
+			a.FlushAll()
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMultilineAggregatorSenders(t *testing.T) {
+	var got []string
+	a, err := newMultilineAggregator(&MultilineConfig{Continue: `^\s`, Timeout: time.Second}, func(m *SyslogMessage) {
+		got = append(got, m.Hostname+": "+m.Content)
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	a.Add(&SyslogMessage{Hostname: "a", Content: "first"}, now)
+	a.Add(&SyslogMessage{Hostname: "b", Content: "second"}, now)
+	a.Add(&SyslogMessage{Hostname: "a", Content: " more"}, now.Add(500*time.Millisecond))
+
+	a.Flush(now.Add(time.Second))
+	assert.Equal(t, []string{"b: second"}, got)
+	a.Flush(now.Add(1500 * time.Millisecond))
+	assert.Equal(t, []string{"b: second", "a: first\n more"}, got)
+}
+
+func TestMultilineAggregatorErrors(t *testing.T) {
+	for _, cfg := range []MultilineConfig{
+		{},
+		{Start: "("},
+		{Continue: "("},
+	} {
+		_, err := newMultilineAggregator(&cfg, nil)
+		assert.Error(t, err)
+	}
+}
+
+func TestSyslogStopFlushesMultiline(t *testing.T) {
+	msgChan := make(chan *Event, 10)
+	s, err := NewSyslog("127.0.0.1:0", "tcp", "RFC5424", msgChan)
+	require.NoError(t, err)
+	require.NoError(t, s.SetMultiline(&MultilineConfig{Continue: `^\s`, Timeout: time.Hour}))
+	s.Run()
+
+	for _, line := range []string{"Exception", "\tat a"} {
+		s.innerChannel <- format.LogParts{"hostname": "h", "app_name": "app", "message": line}
+	}
+	s.Stop()
+	s.Stop()
+
+	require.Len(t, msgChan, 1)
+	data, err := (<-msgChan).Data()
+	require.NoError(t, err)
+	assert.Equal(t, "Exception\n\tat a", data["content"])
+}
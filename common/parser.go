@@ -0,0 +1,457 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bytedance/sonic"
+)
+
+// ContentParser extracts structured fields from the content of a syslog
+// message.
+type ContentParser interface {
+	// Name is the key under which the extracted fields are stored.
+	Name() string
+	// Parse returns the extracted fields, or false when content is not in
+	// the format handled by the parser.
+	Parse(content string) (map[string]interface{}, bool)
+}
+
+// ContentParserConfig represents a content parser entry of a syslog listener
+type ContentParserConfig struct {
+	Type string `yaml:"type"`
+	// Prefix merges the extracted fields into the record as prefix+key
+	// instead of nesting them under the parser name.
+	Prefix string `yaml:"prefix,omitempty"`
+
+	// Key/value extractor options
+	FieldSplit string `yaml:"field_split,omitempty"`
+	ValueSplit string `yaml:"value_split,omitempty"`
+	Quote      string `yaml:"quote,omitempty"`
+}
+
+// NewContentParser creates the content parser described by cfg.
+func NewContentParser(cfg ContentParserConfig) (ContentParser, error) {
+	switch cfg.Type {
+	case "cef":
+		return &cefParser{}, nil
+	case "leef":
+		return &leefParser{}, nil
+	case "cisco_asa":
+		return &ciscoASAParser{}, nil
+	case "juniper":
+		return &juniperParser{}, nil
+	case "kv":
+		p := &kvParser{fieldSplit: ' ', valueSplit: '=', quote: '"'}
+		for _, opt := range []struct {
+			name  string
+			value string
+			dst   *byte
+		}{
+			{"field_split", cfg.FieldSplit, &p.fieldSplit},
+			{"value_split", cfg.ValueSplit, &p.valueSplit},
+			{"quote", cfg.Quote, &p.quote},
+		} {
+			if opt.value == "" {
+				continue
+			}
+			if len(opt.value) != 1 {
+				return nil, fmt.Errorf("kv %s must be a single character", opt.name)
+			}
+			*opt.dst = opt.value[0]
+		}
+		if p.fieldSplit == p.valueSplit {
+			return nil, fmt.Errorf("kv field_split and value_split must differ")
+		}
+		return p, nil
+	case "json":
+		return &jsonParser{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content parser: %s", cfg.Type)
+	}
+}
+
+// reservedFields are the fields of the syslog schema, which extracted fields
+// merged with a prefix never overwrite.
+var reservedFields = map[string]bool{
+	"format": true, "timestamp": true, "hostname": true, "app_name": true,
+	"proc_id": true, "msg_id": true, "priority": true, "severity": true,
+	"severity_name": true, "facility": true, "facility_name": true,
+	"structured_data": true, "content": true, "client": true, "tls_peer": true,
+	"raw": true, "received_at": true,
+}
+
+// ContentParsers applies an ordered list of content parsers to messages.
+type ContentParsers struct {
+	parsers  []ContentParser
+	prefixes []string
+}
+
+// NewContentParsers creates the content parsers described by cfgs.
+func NewContentParsers(cfgs []ContentParserConfig) (*ContentParsers, error) {
+	c := &ContentParsers{}
+	for _, cfg := range cfgs {
+		parser, err := NewContentParser(cfg)
+		if err != nil {
+			return nil, err
+		}
+		c.parsers = append(c.parsers, parser)
+		c.prefixes = append(c.prefixes, cfg.Prefix)
+	}
+	return c, nil
+}
+
+// Apply runs every parser on content and merges the extracted fields into
+// data. The content and the other syslog fields are left untouched.
+func (c *ContentParsers) Apply(data map[string]interface{}, content string) {
+	for i, parser := range c.parsers {
+		fields, ok := parser.Parse(content)
+		if !ok {
+			continue
+		}
+		if c.prefixes[i] == "" {
+			data[parser.Name()] = fields
+			continue
+		}
+		for k, v := range fields {
+			if key := c.prefixes[i] + k; !reservedFields[key] {
+				data[key] = v
+			}
+		}
+	}
+}
+
+// cefParser parses ArcSight CEF:
+// CEF:Version|Device Vendor|Device Product|Device Version|Signature ID|Name|Severity|Extension
+type cefParser struct{}
+
+var cefHeaderFields = []string{"version", "device_vendor", "device_product", "device_version", "signature_id", "name", "severity"}
+
+func (p *cefParser) Name() string {
+	return "cef"
+}
+
+func (p *cefParser) Parse(content string) (map[string]interface{}, bool) {
+	start := strings.Index(content, "CEF:")
+	if start < 0 {
+		return nil, false
+	}
+	header, rest := splitEscaped(content[start+4:], '|', len(cefHeaderFields))
+	if len(header) < len(cefHeaderFields) {
+		return nil, false
+	}
+
+	res := make(map[string]interface{}, len(header)+8)
+	for i, name := range cefHeaderFields {
+		res[name] = header[i]
+	}
+	for k, v := range parseCEFExtension(rest) {
+		res[k] = v
+	}
+	return res, true
+}
+
+// parseCEFExtension parses space separated key=value pairs where values may
+// contain unescaped spaces; a value ends at the space preceding the next key.
+func parseCEFExtension(ext string) map[string]string {
+	res := make(map[string]string)
+	var key string
+	var value strings.Builder
+	var word strings.Builder
+
+	flush := func() {
+		if key != "" {
+			res[key] = strings.TrimSpace(value.String())
+		}
+		value.Reset()
+	}
+
+	for i := 0; i < len(ext); i++ {
+		c := ext[i]
+		switch {
+		case c == '\\' && i+1 < len(ext):
+			i++
+			switch ext[i] {
+			case 'n':
+				word.WriteByte('\n')
+			case 'r':
+				word.WriteByte('\r')
+			default:
+				word.WriteByte(ext[i])
+			}
+		case c == '=':
+			// The current word is the next key
+			flush()
+			key = word.String()
+			word.Reset()
+		case c == ' ':
+			if key != "" {
+				value.WriteString(word.String())
+				value.WriteByte(' ')
+			}
+			word.Reset()
+		default:
+			word.WriteByte(c)
+		}
+	}
+	if key != "" {
+		value.WriteString(word.String())
+	}
+	flush()
+	return res
+}
+
+// leefParser parses IBM LEEF 1.0 and 2.0:
+// LEEF:1.0|Vendor|Product|Version|EventID|attributes
+// LEEF:2.0|Vendor|Product|Version|EventID|Delimiter|attributes
+type leefParser struct{}
+
+var leefHeaderFields = []string{"version", "vendor", "product", "product_version", "event_id"}
+
+func (p *leefParser) Name() string {
+	return "leef"
+}
+
+func (p *leefParser) Parse(content string) (map[string]interface{}, bool) {
+	start := strings.Index(content, "LEEF:")
+	if start < 0 {
+		return nil, false
+	}
+	content = content[start+5:]
+
+	fieldCount := len(leefHeaderFields)
+	if strings.HasPrefix(content, "2.") {
+		fieldCount++
+	}
+	header, rest := splitEscaped(content, '|', fieldCount)
+	if len(header) < fieldCount {
+		return nil, false
+	}
+
+	delimiter := byte('\t')
+	if fieldCount > len(leefHeaderFields) {
+		if d, ok := parseLEEFDelimiter(header[fieldCount-1]); ok {
+			delimiter = d
+		}
+	}
+
+	res := make(map[string]interface{}, len(header)+8)
+	for i, name := range leefHeaderFields {
+		res[name] = header[i]
+	}
+	for k, v := range parseKeyValues(rest, delimiter, '=', 0) {
+		res[k] = v
+	}
+	return res, true
+}
+
+// parseLEEFDelimiter accepts a single character or a hex value such as x09 or 0x09.
+func parseLEEFDelimiter(d string) (byte, bool) {
+	if len(d) == 1 {
+		return d[0], true
+	}
+	d = strings.TrimPrefix(strings.TrimPrefix(d, "0"), "x")
+	n, err := strconv.ParseUint(d, 16, 8)
+	if err != nil || d == "" {
+		return 0, false
+	}
+	return byte(n), true
+}
+
+// ciscoASAParser parses Cisco ASA/FTD messages such as
+// %ASA-6-302013: Built outbound TCP connection ... and extracts any
+// key=value pairs from the message text.
+type ciscoASAParser struct{}
+
+func (p *ciscoASAParser) Name() string {
+	return "cisco"
+}
+
+func (p *ciscoASAParser) Parse(content string) (map[string]interface{}, bool) {
+	start := strings.Index(content, "%ASA-")
+	if start < 0 {
+		start = strings.Index(content, "%FTD-")
+	}
+	if start < 0 {
+		return nil, false
+	}
+	colon := strings.Index(content[start:], ": ")
+	if colon < 0 {
+		return nil, false
+	}
+
+	// %ASA-6-302013
+	parts := strings.SplitN(content[start+1:start+colon], "-", 3)
+	if len(parts) != 3 {
+		return nil, false
+	}
+	message := content[start+colon+2:]
+
+	res := map[string]interface{}{
+		"product":    parts[0],
+		"severity":   parts[1],
+		"message_id": parts[2],
+		"message":    message,
+	}
+	for k, v := range parseKeyValues(message, ' ', '=', '"') {
+		res[k] = v
+	}
+	return res, true
+}
+
+// juniperParser parses Junos structured messages such as
+// RT_FLOW_SESSION_CREATE [junos@2636.1.1.1.2.26 source-address="10.0.0.1" ...]
+// and the plain layout used by brief messages, where the event tag is
+// directly followed by key="value" pairs.
+type juniperParser struct{}
+
+// juniperBriefRe matches an upper case Junos event tag such as
+// RT_FLOW_SESSION_CREATE followed by a first key="value" pair.
+var juniperBriefRe = regexp.MustCompile(`(?:^|\s)([A-Z][A-Z0-9]*(?:_[A-Z0-9]+)+):?\s+[A-Za-z][\w.-]*="`)
+
+func (p *juniperParser) Name() string {
+	return "juniper"
+}
+
+func (p *juniperParser) Parse(content string) (map[string]interface{}, bool) {
+	start := strings.Index(content, "[junos@")
+	if start < 0 {
+		m := juniperBriefRe.FindStringSubmatchIndex(content)
+		if m == nil {
+			return nil, false
+		}
+		res := map[string]interface{}{"event": content[m[2]:m[3]]}
+		for k, v := range parseKeyValues(content[m[3]:], ' ', '=', '"') {
+			res[k] = v
+		}
+		return res, true
+	}
+
+	res := make(map[string]interface{})
+	fields := strings.Fields(content[:start])
+	if len(fields) > 0 {
+		res["event"] = fields[len(fields)-1]
+	}
+	for _, params := range parseStructuredData(content[start:]) {
+		for k, v := range params {
+			res[k] = v
+		}
+	}
+	return res, true
+}
+
+// kvParser parses logfmt style content such as
+// user=alice action=login msg="bad password"
+type kvParser struct {
+	fieldSplit byte
+	valueSplit byte
+	quote      byte
+}
+
+func (p *kvParser) Name() string {
+	return "kv"
+}
+
+func (p *kvParser) Parse(content string) (map[string]interface{}, bool) {
+	pairs := parseKeyValues(content, p.fieldSplit, p.valueSplit, p.quote)
+	if len(pairs) == 0 {
+		return nil, false
+	}
+	res := make(map[string]interface{}, len(pairs))
+	for k, v := range pairs {
+		res[k] = v
+	}
+	return res, true
+}
+
+// jsonParser extracts a JSON object embedded in the content, optionally
+// after a free text prefix such as "audit: {...}".
+type jsonParser struct{}
+
+func (p *jsonParser) Name() string {
+	return "json"
+}
+
+func (p *jsonParser) Parse(content string) (map[string]interface{}, bool) {
+	start := strings.IndexByte(content, '{')
+	end := strings.LastIndexByte(content, '}')
+	if start < 0 || end < start {
+		return nil, false
+	}
+	var res map[string]interface{}
+	if err := sonic.UnmarshalString(content[start:end+1], &res); err != nil || res == nil {
+		return nil, false
+	}
+	return res, true
+}
+
+// splitEscaped splits s on sep at most n times, honouring backslash escapes,
+// and returns the unescaped fields and the remaining unsplit text.
+func splitEscaped(s string, sep byte, n int) ([]string, string) {
+	var fields []string
+	var sb strings.Builder
+	i := 0
+	for ; i < len(s) && len(fields) < n; i++ {
+		c := s[i]
+		if c == '\\' && i+1 < len(s) && (s[i+1] == sep || s[i+1] == '\\') {
+			i++
+			sb.WriteByte(s[i])
+		} else if c == sep {
+			fields = append(fields, sb.String())
+			sb.Reset()
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return fields, s[i:]
+}
+
+// parseKeyValues parses key/value pairs separated by pairSep, with keys and
+// values separated by kvSep. A value starting with quote (when non-zero)
+// extends to the matching closing quote and may contain pairSep.
+// Tokens without kvSep are ignored.
+func parseKeyValues(s string, pairSep, kvSep, quote byte) map[string]string {
+	res := make(map[string]string)
+	i := 0
+	for i < len(s) {
+		for i < len(s) && s[i] == pairSep {
+			i++
+		}
+		start := i
+		for i < len(s) && s[i] != kvSep && s[i] != pairSep {
+			i++
+		}
+		if i >= len(s) || s[i] != kvSep {
+			continue
+		}
+		key := strings.TrimSpace(s[start:i])
+		i++
+
+		var value string
+		if quote != 0 && i < len(s) && s[i] == quote {
+			i++
+			var sb strings.Builder
+			for i < len(s) && s[i] != quote {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				sb.WriteByte(s[i])
+				i++
+			}
+			i++
+			value = sb.String()
+		} else {
+			start = i
+			for i < len(s) && s[i] != pairSep {
+				i++
+			}
+			value = s[start:i]
+		}
+		if key != "" {
+			res[key] = value
+		}
+	}
+	return res
+}
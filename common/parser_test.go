@@ -0,0 +1,276 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentParsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		parser  string
+		content string
+		want    map[string]interface{}
+	}{
+		{
+			name:    "cef",
+			parser:  "cef",
+			content: `CEF:0|Security|threatmanager|1.0|100|worm successfully stopped|10|src=10.0.0.1 dst=2.1.2.2 spt=1232 msg=Detected a threat. No action needed`,
+			want: map[string]interface{}{
+				"version":        "0",
+				"device_vendor":  "Security",
+				"device_product": "threatmanager",
+				"device_version": "1.0",
+				"signature_id":   "100",
+				"name":           "worm successfully stopped",
+				"severity":       "10",
+				"src":            "10.0.0.1",
+				"dst":            "2.1.2.2",
+				"spt":            "1232",
+				"msg":            "Detected a threat. No action needed",
+			},
+		},
+		{
+			name:    "cef escapes after syslog prefix",
+			parser:  "cef",
+			content: `host CEF:0|Vendor\|Inc|Product|1|sig|a\\b|5|msg=line1\nline2 eq\=sign act=blocked`,
+			want: map[string]interface{}{
+				"version":        "0",
+				"device_vendor":  "Vendor|Inc",
+				"device_product": "Product",
+				"device_version": "1",
+				"signature_id":   "sig",
+				"name":           `a\b`,
+				"severity":       "5",
+				"msg":            "line1\nline2 eq=sign",
+				"act":            "blocked",
+			},
+		},
+		{
+			name:    "leef 1.0",
+			parser:  "leef",
+			content: "LEEF:1.0|Microsoft|MSExchange|4.0 SP1|15345|src=192.0.2.0\tdst=172.50.123.1\tsev=5",
+			want: map[string]interface{}{
+				"version":         "1.0",
+				"vendor":          "Microsoft",
+				"product":         "MSExchange",
+				"product_version": "4.0 SP1",
+				"event_id":        "15345",
+				"src":             "192.0.2.0",
+				"dst":             "172.50.123.1",
+				"sev":             "5",
+			},
+		},
+		{
+			name:    "leef 2.0 with hex delimiter",
+			parser:  "leef",
+			content: "LEEF:2.0|Lancope|StealthWatch|1.0|41|x5E|src=10.0.1.8^dst=10.0.0.5^sev=5",
+			want: map[string]interface{}{
+				"version":         "2.0",
+				"vendor":          "Lancope",
+				"product":         "StealthWatch",
+				"product_version": "1.0",
+				"event_id":        "41",
+				"src":             "10.0.1.8",
+				"dst":             "10.0.0.5",
+				"sev":             "5",
+			},
+		},
+		{
+			name:    "leef 2.0 with character delimiter",
+			parser:  "leef",
+			content: "LEEF:2.0|V|P|1|id|;|a=1;b=2",
+			want: map[string]interface{}{
+				"version":         "2.0",
+				"vendor":          "V",
+				"product":         "P",
+				"product_version": "1",
+				"event_id":        "id",
+				"a":               "1",
+				"b":               "2",
+			},
+		},
+		{
+			name:    "cisco asa",
+			parser:  "cisco_asa",
+			content: `%ASA-6-302013: Built outbound TCP connection 1 for outside:10.0.0.2/443 (10.0.0.2/443) to inside:192.168.1.5/5555`,
+			want: map[string]interface{}{
+				"product":    "ASA",
+				"severity":   "6",
+				"message_id": "302013",
+				"message":    "Built outbound TCP connection 1 for outside:10.0.0.2/443 (10.0.0.2/443) to inside:192.168.1.5/5555",
+			},
+		},
+		{
+			name:    "cisco ftd with key values",
+			parser:  "cisco_asa",
+			content: `%FTD-1-430003: DeviceUUID: x, AccessControlRuleAction=Block SrcIP="10.1.1.1"`,
+			want: map[string]interface{}{
+				"product":                 "FTD",
+				"severity":                "1",
+				"message_id":              "430003",
+				"message":                 `DeviceUUID: x, AccessControlRuleAction=Block SrcIP="10.1.1.1"`,
+				"AccessControlRuleAction": "Block",
+				"SrcIP":                   "10.1.1.1",
+			},
+		},
+		{
+			name:    "juniper structured",
+			parser:  "juniper",
+			content: `RT_FLOW_SESSION_CREATE [junos@2636.1.1.1.2.26 source-address="10.0.0.1" source-port="5000" destination-address="10.0.0.2"]`,
+			want: map[string]interface{}{
+				"event":               "RT_FLOW_SESSION_CREATE",
+				"source-address":      "10.0.0.1",
+				"source-port":         "5000",
+				"destination-address": "10.0.0.2",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser, err := NewContentParser(ContentParserConfig{Type: tt.parser})
+			require.NoError(t, err)
+			got, ok := parser.Parse(tt.content)
+			require.True(t, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestContentParsersNoMatch(t *testing.T) {
+	tests := []struct {
+		parser  string
+		content string
+	}{
+		{"cef", "plain message"},
+		{"cef", "CEF:0|only|three"},
+		{"leef", "LEEF:1.0|Vendor"},
+		{"cisco_asa", "%ASA-6-302013 no colon"},
+		{"cisco_asa", "%ASA-6: too few parts"},
+		{"juniper", "plain message"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.parser+" "+tt.content, func(t *testing.T) {
+			parser, err := NewContentParser(ContentParserConfig{Type: tt.parser})
+			require.NoError(t, err)
+			_, ok := parser.Parse(tt.content)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestExtractors(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     ContentParserConfig
+		content string
+		want    map[string]interface{}
+		ok      bool
+	}{
+		{
+			name:    "kv",
+			cfg:     ContentParserConfig{Type: "kv"},
+			content: `user=alice action=login msg="bad password" flag`,
+			want:    map[string]interface{}{"user": "alice", "action": "login", "msg": "bad password"},
+			ok:      true,
+		},
+		{
+			name:    "kv custom separators",
+			cfg:     ContentParserConfig{Type: "kv", FieldSplit: ",", ValueSplit: ":", Quote: "'"},
+			content: `user:alice,msg:'a, b',status:ok`,
+			want:    map[string]interface{}{"user": "alice", "msg": "a, b", "status": "ok"},
+			ok:      true,
+		},
+		{
+			name:    "kv without pairs",
+			cfg:     ContentParserConfig{Type: "kv"},
+			content: "plain message",
+		},
+		{
+			name:    "json after prefix",
+			cfg:     ContentParserConfig{Type: "json"},
+			content: `audit: {"user":"alice","ok":true}`,
+			want:    map[string]interface{}{"user": "alice", "ok": true},
+			ok:      true,
+		},
+		{
+			name:    "json array",
+			cfg:     ContentParserConfig{Type: "json"},
+			content: `[1, 2]`,
+		},
+		{
+			name:    "invalid json",
+			cfg:     ContentParserConfig{Type: "json"},
+			content: `{not json}`,
+		},
+		{
+			name:    "juniper brief",
+			cfg:     ContentParserConfig{Type: "juniper"},
+			content: `RT_FLOW: RT_FLOW_SESSION_DENY: source-address="10.0.0.1" reason="policy deny"`,
+			want:    map[string]interface{}{"event": "RT_FLOW_SESSION_DENY", "source-address": "10.0.0.1", "reason": "policy deny"},
+			ok:      true,
+		},
+		{
+			name:    "juniper ignores other key values",
+			cfg:     ContentParserConfig{Type: "juniper"},
+			content: `user="alice" action="login"`,
+		},
+		{
+			name:    "juniper ignores lower case tags",
+			cfg:     ContentParserConfig{Type: "juniper"},
+			content: `app started with config="/etc/app.conf"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser, err := NewContentParser(tt.cfg)
+			require.NoError(t, err)
+			got, ok := parser.Parse(tt.content)
+			assert.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestContentParsersApply(t *testing.T) {
+	content := `user=alice content=evil hostname=spoofed`
+
+	parsers, err := NewContentParsers([]ContentParserConfig{{Type: "kv"}})
+	require.NoError(t, err)
+	data := map[string]interface{}{"content": content, "hostname": "web-1"}
+	parsers.Apply(data, content)
+	assert.Equal(t, map[string]interface{}{
+		"content":  content,
+		"hostname": "web-1",
+		"kv":       map[string]interface{}{"user": "alice", "content": "evil", "hostname": "spoofed"},
+	}, data)
+
+	// Prefixed keys, such as host+name, never replace syslog fields
+	for _, prefix := range []string{"kv_", "host"} {
+		parsers, err = NewContentParsers([]ContentParserConfig{{Type: "kv", Prefix: prefix}})
+		require.NoError(t, err)
+		data = map[string]interface{}{"content": content, "hostname": "web-1"}
+		parsers.Apply(data, content)
+		assert.Equal(t, content, data["content"])
+		assert.Equal(t, "web-1", data["hostname"])
+		assert.Equal(t, "alice", data[prefix+"user"])
+	}
+}
+
+func TestNewContentParserErrors(t *testing.T) {
+	for _, cfg := range []ContentParserConfig{
+		{Type: "unknown"},
+		{Type: "kv", FieldSplit: "ab"},
+		{Type: "kv", FieldSplit: "=", ValueSplit: "="},
+	} {
+		_, err := NewContentParser(cfg)
+		assert.Error(t, err, cfg)
+	}
+}
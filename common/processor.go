@@ -0,0 +1,670 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Processor transforms events between ingest and Kafka.
+type Processor interface {
+	// Process returns the events to forward in place of evt. An empty
+	// result drops the event.
+	Process(evt *Event) ([]*Event, error)
+}
+
+// ConditionConfig represents a field condition. Exactly one of Equals, In,
+// Regex or Exists, or any of the numeric comparisons, is expected; Not
+// inverts the result.
+type ConditionConfig struct {
+	Field  string   `yaml:"field"`
+	Equals *string  `yaml:"equals,omitempty"`
+	In     []string `yaml:"in,omitempty"`
+	Regex  string   `yaml:"regex,omitempty"`
+	Exists *bool    `yaml:"exists,omitempty"`
+	Lt     *float64 `yaml:"lt,omitempty"`
+	Lte    *float64 `yaml:"lte,omitempty"`
+	Gt     *float64 `yaml:"gt,omitempty"`
+	Gte    *float64 `yaml:"gte,omitempty"`
+	Not    bool     `yaml:"not,omitempty"`
+}
+
+// ProcessorConfig represents one step of a processor chain
+type ProcessorConfig struct {
+	Type string `yaml:"type"`
+	// If restricts the processor to events matching the condition
+	If *ConditionConfig `yaml:"if,omitempty"`
+
+	Field     string      `yaml:"field,omitempty"`
+	Fields    []string    `yaml:"fields,omitempty"`
+	Target    string      `yaml:"target,omitempty"`
+	Value     interface{} `yaml:"value,omitempty"`
+	Overwrite bool        `yaml:"overwrite,omitempty"`
+	Tags      []string    `yaml:"tags,omitempty"`
+	To        string      `yaml:"to,omitempty"`
+	Formats   []string    `yaml:"formats,omitempty"`
+	Timezone  string      `yaml:"timezone,omitempty"`
+
+	// Filter and sample processor options
+	Include   []ConditionConfig `yaml:"include,omitempty"`
+	Exclude   []ConditionConfig `yaml:"exclude,omitempty"`
+	Ratio     float64           `yaml:"ratio,omitempty"`
+	PerSecond float64           `yaml:"per_second,omitempty"`
+	Burst     int               `yaml:"burst,omitempty"`
+
+	// Dedup processor options
+	Size int           `yaml:"size,omitempty"`
+	TTL  time.Duration `yaml:"ttl,omitempty"`
+
+	// GeoIP processor options
+	Database       string        `yaml:"database,omitempty"`
+	ASNDatabase    string        `yaml:"asn_database,omitempty"`
+	ReverseDNS     bool          `yaml:"reverse_dns,omitempty"`
+	DNSCacheSize   int           `yaml:"dns_cache_size,omitempty"`
+	DNSTTL         time.Duration `yaml:"dns_ttl,omitempty"`
+	DNSNegativeTTL time.Duration `yaml:"dns_negative_ttl,omitempty"`
+	DNSTimeout     time.Duration `yaml:"dns_timeout,omitempty"`
+
+	// Lookup processor options
+	File           string        `yaml:"file,omitempty"`
+	Key            string        `yaml:"key,omitempty"`
+	ReloadInterval time.Duration `yaml:"reload_interval,omitempty"`
+
+	// Redact processor options
+	Action    string   `yaml:"action,omitempty"`
+	Detectors []string `yaml:"detectors,omitempty"`
+	Patterns  []string `yaml:"patterns,omitempty"`
+	Salt      string   `yaml:"salt,omitempty"`
+
+	// Script processor options
+	Name    string        `yaml:"name,omitempty"`
+	Script  string        `yaml:"script,omitempty"`
+	Source  string        `yaml:"source,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// Condition is a compiled ConditionConfig
+type Condition struct {
+	field  []string
+	equals *string
+	in     map[string]struct{}
+	regex  *regexp.Regexp
+	exists *bool
+	lt     *float64
+	lte    *float64
+	gt     *float64
+	gte    *float64
+	not    bool
+}
+
+// NewCondition compiles cfg. Field paths are resolved with GetCheckData, so
+// they may descend into JSON or query strings.
+func NewCondition(cfg *ConditionConfig) (*Condition, error) {
+	if cfg.Field == "" {
+		return nil, fmt.Errorf("condition field is required")
+	}
+	c := &Condition{
+		field:  StringToList(cfg.Field),
+		equals: cfg.Equals,
+		exists: cfg.Exists,
+		lt:     cfg.Lt,
+		lte:    cfg.Lte,
+		gt:     cfg.Gt,
+		gte:    cfg.Gte,
+		not:    cfg.Not,
+	}
+	if len(cfg.In) > 0 {
+		c.in = make(map[string]struct{}, len(cfg.In))
+		for _, v := range cfg.In {
+			c.in[v] = struct{}{}
+		}
+	}
+	if cfg.Regex != "" {
+		re, err := regexp.Compile(cfg.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition regex: %w", err)
+		}
+		c.regex = re
+	}
+	if c.equals == nil && c.in == nil && c.regex == nil && c.exists == nil && !c.numeric() {
+		return nil, fmt.Errorf("condition on %s needs one of equals, in, regex, exists, lt, lte, gt or gte", cfg.Field)
+	}
+	return c, nil
+}
+
+// Match reports whether data satisfies the condition.
+func (c *Condition) Match(data map[string]interface{}) bool {
+	value, ok := GetCheckData(data, c.field)
+	var res bool
+	switch {
+	case c.exists != nil:
+		res = ok == *c.exists
+	case !ok:
+		res = false
+	case c.equals != nil:
+		res = value == *c.equals
+	case c.in != nil:
+		_, res = c.in[value]
+	case c.regex != nil:
+		res = c.regex.MatchString(value)
+	default:
+		res = c.compare(value)
+	}
+	return res != c.not
+}
+
+func (c *Condition) numeric() bool {
+	return c.lt != nil || c.lte != nil || c.gt != nil || c.gte != nil
+}
+
+// compare checks value against every configured numeric bound.
+func (c *Condition) compare(value string) bool {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return false
+	}
+	return (c.lt == nil || n < *c.lt) &&
+		(c.lte == nil || n <= *c.lte) &&
+		(c.gt == nil || n > *c.gt) &&
+		(c.gte == nil || n >= *c.gte)
+}
+
+// Pipeline is an ordered chain of processors
+type Pipeline struct {
+	processors []Processor
+}
+
+// NewPipeline creates the processor chain described by cfgs.
+func NewPipeline(cfgs []ProcessorConfig) (*Pipeline, error) {
+	p := &Pipeline{}
+	for i, cfg := range cfgs {
+		processor, err := NewProcessor(cfg)
+		if err != nil {
+			_ = p.Close()
+			return nil, fmt.Errorf("processor[%d]: %w", i, err)
+		}
+		p.processors = append(p.processors, processor)
+	}
+	return p, nil
+}
+
+// Close releases what the processors hold open, such as databases. A nil
+// pipeline has nothing to close.
+func (p *Pipeline) Close() error {
+	if p == nil {
+		return nil
+	}
+	var errs []error
+	for _, processor := range p.processors {
+		if err := closeProcessor(processor); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closeProcessor closes processor if it implements io.Closer.
+func closeProcessor(processor Processor) error {
+	if c, ok := processor.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Process runs evt through every processor and returns the resulting
+// events. An event failing a processor is dropped, counted under
+// processor.errors and reported in the returned error, while the events
+// split from it still go through the remaining processors. A nil pipeline
+// returns evt unchanged.
+func (p *Pipeline) Process(evt *Event) ([]*Event, error) {
+	events := []*Event{evt}
+	if p == nil {
+		return events, nil
+	}
+	var errs []error
+	for _, processor := range p.processors {
+		var next []*Event
+		for _, e := range events {
+			out, err := processor.Process(e)
+			if err != nil {
+				AddMetric("processor.errors", 1)
+				errs = append(errs, err)
+				continue
+			}
+			next = append(next, out...)
+		}
+		events = next
+		if len(events) == 0 {
+			break
+		}
+	}
+	return events, errors.Join(errs...)
+}
+
+// NewProcessor creates a single processor from cfg.
+func NewProcessor(cfg ProcessorConfig) (Processor, error) {
+	var processor Processor
+	var fn fieldFunc
+	var keep predicate
+	var err error
+
+	switch cfg.Type {
+	case "add":
+		fn, err = addProcessor(cfg)
+	case "rename", "copy":
+		fn, err = moveProcessor(cfg)
+	case "remove":
+		fn, err = removeProcessor(cfg)
+	case "tag":
+		fn, err = tagProcessor(cfg)
+	case "drop":
+		if cfg.If == nil {
+			return nil, fmt.Errorf("drop requires an if condition")
+		}
+		keep = func(map[string]interface{}) bool { return false }
+	case "convert":
+		fn, err = convertProcessor(cfg)
+	case "lowercase", "uppercase", "trim":
+		fn, err = stringProcessor(cfg)
+	case "timestamp":
+		fn, err = timestampProcessor(cfg)
+	case "filter":
+		keep, err = filterProcessor(cfg)
+	case "sample":
+		keep, err = sampleProcessor(cfg)
+	case "dedup":
+		keep, err = dedupProcessor(cfg)
+	case "geoip":
+		processor, err = newGeoIPProcessor(cfg)
+	case "lookup":
+		processor, err = newLookupProcessor(cfg)
+	case "redact":
+		fn, err = redactProcessor(cfg)
+	case "script":
+		processor, err = newScriptProcessor(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported processor type: %s", cfg.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.Type, err)
+	}
+	if fn != nil {
+		processor = mapProcessor(fn)
+	}
+	if keep != nil {
+		processor = predicateProcessor(keep)
+	}
+
+	if cfg.If != nil {
+		cond, err := NewCondition(cfg.If)
+		if err != nil {
+			_ = closeProcessor(processor)
+			return nil, fmt.Errorf("%s: %w", cfg.Type, err)
+		}
+		processor = &conditionalProcessor{cond: cond, processor: processor}
+	}
+	return processor, nil
+}
+
+// conditionalProcessor runs processor only on events matching cond.
+type conditionalProcessor struct {
+	cond      *Condition
+	processor Processor
+}
+
+func (p *conditionalProcessor) Process(evt *Event) ([]*Event, error) {
+	data, err := evt.Data()
+	if err != nil {
+		return nil, err
+	}
+	if !p.cond.Match(data) {
+		return []*Event{evt}, nil
+	}
+	return p.processor.Process(evt)
+}
+
+func (p *conditionalProcessor) Close() error {
+	return closeProcessor(p.processor)
+}
+
+// predicate reports whether an event is kept, without modifying it.
+type predicate func(data map[string]interface{}) bool
+
+// predicateProcessor adapts a predicate to the Processor interface.
+type predicateProcessor predicate
+
+func (keep predicateProcessor) Process(evt *Event) ([]*Event, error) {
+	data, err := evt.Data()
+	if err != nil {
+		return nil, err
+	}
+	if !keep(data) {
+		return nil, nil
+	}
+	return []*Event{evt}, nil
+}
+
+// fieldFunc modifies data in place and reports whether it changed anything.
+type fieldFunc func(data map[string]interface{}) (bool, error)
+
+// mapProcessor adapts a fieldFunc to the Processor interface.
+type mapProcessor fieldFunc
+
+func (fn mapProcessor) Process(evt *Event) ([]*Event, error) {
+	data, err := evt.Data()
+	if err != nil {
+		return nil, err
+	}
+	changed, err := fn(data)
+	if changed {
+		// Only re-encode events that were actually modified
+		evt.MarkDirty()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []*Event{evt}, nil
+}
+
+func addProcessor(cfg ProcessorConfig) (fieldFunc, error) {
+	if cfg.Field == "" {
+		return nil, fmt.Errorf("field is required")
+	}
+	path := StringToList(cfg.Field)
+	return func(data map[string]interface{}) (bool, error) {
+		if _, ok := GetField(data, path); ok && !cfg.Overwrite {
+			return false, nil
+		}
+		SetField(data, path, cfg.Value)
+		return true, nil
+	}, nil
+}
+
+func moveProcessor(cfg ProcessorConfig) (fieldFunc, error) {
+	if cfg.Field == "" || cfg.Target == "" {
+		return nil, fmt.Errorf("field and target are required")
+	}
+	from := StringToList(cfg.Field)
+	to := StringToList(cfg.Target)
+	rename := cfg.Type == "rename"
+	return func(data map[string]interface{}) (bool, error) {
+		value, ok := GetField(data, from)
+		if !ok {
+			return false, nil
+		}
+		if _, exists := GetField(data, to); exists && !cfg.Overwrite {
+			return false, nil
+		}
+		if rename {
+			DeleteField(data, from)
+		}
+		SetField(data, to, value)
+		return true, nil
+	}, nil
+}
+
+func removeProcessor(cfg ProcessorConfig) (fieldFunc, error) {
+	paths, err := fieldPaths(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return func(data map[string]interface{}) (bool, error) {
+		changed := false
+		for _, path := range paths {
+			if _, ok := GetField(data, path); ok {
+				DeleteField(data, path)
+				changed = true
+			}
+		}
+		return changed, nil
+	}, nil
+}
+
+func tagProcessor(cfg ProcessorConfig) (fieldFunc, error) {
+	if len(cfg.Tags) == 0 {
+		return nil, fmt.Errorf("tags are required")
+	}
+	field := cfg.Field
+	if field == "" {
+		field = "tags"
+	}
+	path := StringToList(field)
+	return func(data map[string]interface{}) (bool, error) {
+		AppendTags(data, path, cfg.Tags...)
+		return true, nil
+	}, nil
+}
+
+// AppendTags appends tags to the list at path, turning a scalar value into
+// a list first.
+func AppendTags(data map[string]interface{}, path []string, tags ...string) {
+	var list []interface{}
+	if existing, ok := GetField(data, path); ok {
+		if l, ok := existing.([]interface{}); ok {
+			list = l
+		} else {
+			list = []interface{}{existing}
+		}
+	}
+	for _, tag := range tags {
+		list = append(list, tag)
+	}
+	SetField(data, path, list)
+}
+
+func convertProcessor(cfg ProcessorConfig) (fieldFunc, error) {
+	paths, err := fieldPaths(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var convert func(string) (interface{}, error)
+	switch cfg.To {
+	case "string":
+		convert = func(s string) (interface{}, error) { return s, nil }
+	case "int":
+		convert = func(s string) (interface{}, error) { return strconv.ParseInt(strings.TrimSpace(s), 10, 64) }
+	case "float":
+		convert = func(s string) (interface{}, error) { return strconv.ParseFloat(strings.TrimSpace(s), 64) }
+	case "bool":
+		convert = func(s string) (interface{}, error) { return strconv.ParseBool(strings.TrimSpace(s)) }
+	default:
+		return nil, fmt.Errorf("unsupported conversion: %s", cfg.To)
+	}
+	return func(data map[string]interface{}) (bool, error) {
+		changed := false
+		for _, path := range paths {
+			value, ok := GetField(data, path)
+			if !ok {
+				continue
+			}
+			converted, err := convert(AnyToString(value))
+			if err != nil {
+				return changed, fmt.Errorf("convert %s to %s: %w", strings.Join(path, "."), cfg.To, err)
+			}
+			if converted != value {
+				SetField(data, path, converted)
+				changed = true
+			}
+		}
+		return changed, nil
+	}, nil
+}
+
+func stringProcessor(cfg ProcessorConfig) (fieldFunc, error) {
+	paths, err := fieldPaths(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var fn func(string) string
+	switch cfg.Type {
+	case "lowercase":
+		fn = strings.ToLower
+	case "uppercase":
+		fn = strings.ToUpper
+	default:
+		fn = strings.TrimSpace
+	}
+	return func(data map[string]interface{}) (bool, error) {
+		changed := false
+		for _, path := range paths {
+			if value, ok := GetField(data, path); ok {
+				if str, ok := value.(string); ok {
+					if res := fn(str); res != str {
+						SetField(data, path, res)
+						changed = true
+					}
+				}
+			}
+		}
+		return changed, nil
+	}, nil
+}
+
+var timestampLayouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"UnixDate":    time.UnixDate,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC850":      time.RFC850,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3164":     time.Stamp,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"DateTime":    time.DateTime,
+}
+
+// timestampProcessor parses a field with the first matching format and
+// rewrites it (or target) as an RFC3339 timestamp in UTC. Besides Go
+// layouts and the names in timestampLayouts, the formats unix and unix_ms
+// accept epoch seconds and milliseconds.
+func timestampProcessor(cfg ProcessorConfig) (fieldFunc, error) {
+	if cfg.Field == "" {
+		return nil, fmt.Errorf("field is required")
+	}
+	formats := cfg.Formats
+	if len(formats) == 0 {
+		formats = []string{"RFC3339"}
+	}
+	loc := time.UTC
+	if cfg.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return nil, err
+		}
+	}
+	from := StringToList(cfg.Field)
+	to := from
+	if cfg.Target != "" {
+		to = StringToList(cfg.Target)
+	}
+
+	return func(data map[string]interface{}) (bool, error) {
+		value, ok := GetField(data, from)
+		if !ok {
+			return false, nil
+		}
+		str := AnyToString(value)
+		for _, format := range formats {
+			if ts, ok := parseTimestamp(str, format, loc); ok {
+				SetField(data, to, ts.UTC().Format(time.RFC3339Nano))
+				return true, nil
+			}
+		}
+		return false, fmt.Errorf("timestamp %q matches none of the configured formats", str)
+	}, nil
+}
+
+func parseTimestamp(value, format string, loc *time.Location) (time.Time, bool) {
+	switch format {
+	case "unix", "unix_ms":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if format == "unix_ms" {
+			return time.UnixMilli(int64(f)), true
+		}
+		return time.Unix(0, int64(f*float64(time.Second))), true
+	}
+	if layout, ok := timestampLayouts[format]; ok {
+		format = layout
+	}
+	ts, err := time.ParseInLocation(format, value, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if ts.Year() == 0 {
+		// Layouts such as RFC3164 carry no year
+		ts = ts.AddDate(time.Now().In(loc).Year(), 0, 0)
+	}
+	return ts, true
+}
+
+func fieldPaths(cfg ProcessorConfig) ([][]string, error) {
+	fields := cfg.Fields
+	if cfg.Field != "" {
+		fields = append([]string{cfg.Field}, fields...)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("field or fields is required")
+	}
+	paths := make([][]string, 0, len(fields))
+	for _, f := range fields {
+		paths = append(paths, StringToList(f))
+	}
+	return paths, nil
+}
+
+// GetField returns the value at path, descending only into nested maps.
+func GetField(data map[string]interface{}, path []string) (interface{}, bool) {
+	var cur interface{} = data
+	for _, k := range path {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// SetField sets the value at path, creating intermediate maps and replacing
+// intermediate values that are not maps.
+func SetField(data map[string]interface{}, path []string, value interface{}) {
+	if len(path) == 0 {
+		return
+	}
+	cur := data
+	for _, k := range path[:len(path)-1] {
+		next, ok := cur[k].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			cur[k] = next
+		}
+		cur = next
+	}
+	cur[path[len(path)-1]] = value
+}
+
+// DeleteField removes the value at path if present.
+func DeleteField(data map[string]interface{}, path []string) {
+	if len(path) == 0 {
+		return
+	}
+	cur := data
+	for _, k := range path[:len(path)-1] {
+		next, ok := cur[k].(map[string]interface{})
+		if !ok {
+			return
+		}
+		cur = next
+	}
+	delete(cur, path[len(path)-1])
+}
@@ -0,0 +1,182 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessors(t *testing.T) {
+	equals := func(s string) *string { return &s }
+
+	tests := []struct {
+		name string
+		cfgs []ProcessorConfig
+		in   string
+		want []string
+	}{
+		{
+			name: "add",
+			cfgs: []ProcessorConfig{{Type: "add", Field: "env.name", Value: "prod"}},
+			in:   `{"a":1}`,
+			want: []string{`{"a":1,"env":{"name":"prod"}}`},
+		},
+		{
+			name: "add keeps existing value",
+			cfgs: []ProcessorConfig{{Type: "add", Field: "a", Value: 2}},
+			in:   `{"a":1}`,
+			want: []string{`{"a":1}`},
+		},
+		{
+			name: "rename and copy",
+			cfgs: []ProcessorConfig{
+				{Type: "rename", Field: "a", Target: "b"},
+				{Type: "copy", Field: "b", Target: "c.d"},
+			},
+			in:   `{"a":"x"}`,
+			want: []string{`{"b":"x","c":{"d":"x"}}`},
+		},
+		{
+			name: "remove and tag",
+			cfgs: []ProcessorConfig{
+				{Type: "remove", Fields: []string{"a", "b"}},
+				{Type: "tag", Tags: []string{"t1", "t2"}},
+			},
+			in:   `{"a":1,"b":2,"c":3,"tags":"t0"}`,
+			want: []string{`{"c":3,"tags":["t0","t1","t2"]}`},
+		},
+		{
+			name: "convert and case",
+			cfgs: []ProcessorConfig{
+				{Type: "convert", Field: "n", To: "int"},
+				{Type: "uppercase", Field: "s"},
+				{Type: "trim", Field: "p"},
+			},
+			in:   `{"n":"42","s":"abc","p":"  x "}`,
+			want: []string{`{"n":42,"p":"x","s":"ABC"}`},
+		},
+		{
+			name: "timestamp",
+			cfgs: []ProcessorConfig{{Type: "timestamp", Field: "ts", Formats: []string{"unix", "RFC3339"}}},
+			in:   `{"ts":"1714564800"}`,
+			want: []string{`{"ts":"2024-05-01T12:00:00Z"}`},
+		},
+		{
+			name: "conditional drop",
+			cfgs: []ProcessorConfig{{Type: "drop", If: &ConditionConfig{Field: "level", Equals: equals("debug")}}},
+			in:   `{"level":"debug"}`,
+			want: nil,
+		},
+		{
+			name: "condition not matching",
+			cfgs: []ProcessorConfig{{Type: "drop", If: &ConditionConfig{Field: "level", Equals: equals("debug")}}},
+			in:   `{"level":"info"}`,
+			want: []string{`{"level":"info"}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, err := NewPipeline(tt.cfgs)
+			require.NoError(t, err)
+			evt, err := ParseEvent([]byte(tt.in))
+			require.NoError(t, err)
+
+			events, err := pipeline.Process(evt)
+			require.NoError(t, err)
+			var got []string
+			for _, e := range events {
+				raw, err := e.Bytes()
+				require.NoError(t, err)
+				got = append(got, string(raw))
+			}
+			if len(tt.want) != len(got) {
+				t.Fatalf("got %d events, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				assert.JSONEq(t, tt.want[i], got[i])
+			}
+		})
+	}
+}
+
+// splitProcessor turns an event into one event per element of its "items"
+// list.
+type splitProcessor struct{}
+
+func (splitProcessor) Process(evt *Event) ([]*Event, error) {
+	data, err := evt.Data()
+	if err != nil {
+		return nil, err
+	}
+	var res []*Event
+	for _, item := range data["items"].([]interface{}) {
+		res = append(res, NewEventFromMap(map[string]interface{}{"n": item}))
+	}
+	return res, nil
+}
+
+func TestPipelineErrors(t *testing.T) {
+	convert, err := NewProcessor(ProcessorConfig{Type: "convert", Field: "n", To: "int"})
+	require.NoError(t, err)
+	tag, err := NewProcessor(ProcessorConfig{Type: "tag", Tags: []string{"done"}})
+	require.NoError(t, err)
+	pipeline := &Pipeline{processors: []Processor{splitProcessor{}, convert, tag}}
+
+	evt, err := ParseEvent([]byte(`{"items":["1","x","3"]}`))
+	require.NoError(t, err)
+	events, err := pipeline.Process(evt)
+	assert.ErrorContains(t, err, `convert n to int`)
+
+	// The failing event is dropped, the others run through every processor
+	require.Len(t, events, 2)
+	for i, want := range []int64{1, 3} {
+		data, err := events[i].Data()
+		require.NoError(t, err)
+		assert.Equal(t, want, data["n"])
+		assert.Equal(t, []interface{}{"done"}, data["tags"])
+	}
+
+	// Payloads that are not objects fail every processor and are dropped
+	evt, err = ParseEvent([]byte(`[1,2]`))
+	require.NoError(t, err)
+	events, err = pipeline.Process(evt)
+	assert.ErrorIs(t, err, errNotObject)
+	assert.Empty(t, events)
+}
+
+func TestPipelineNil(t *testing.T) {
+	var pipeline *Pipeline
+	evt := NewEvent([]byte(`[1]`))
+	events, err := pipeline.Process(evt)
+	require.NoError(t, err)
+	assert.Equal(t, []*Event{evt}, events)
+}
+
+// closingProcessor records whether it was closed.
+type closingProcessor struct {
+	splitProcessor
+	closed bool
+}
+
+func (p *closingProcessor) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestPipelineClose(t *testing.T) {
+	direct := &closingProcessor{}
+	conditional := &closingProcessor{}
+	pipeline := &Pipeline{processors: []Processor{
+		direct,
+		&conditionalProcessor{processor: conditional},
+		splitProcessor{},
+	}}
+	require.NoError(t, pipeline.Close())
+	assert.True(t, direct.closed)
+	assert.True(t, conditional.closed)
+
+	var nilPipeline *Pipeline
+	assert.NoError(t, nilPipeline.Close())
+}
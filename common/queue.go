@@ -0,0 +1,421 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/bytedance/sonic"
+)
+
+// Queue overflow policies
+const (
+	OverflowBlock      = "block"
+	OverflowDropNewest = "drop_newest"
+	OverflowDropOldest = "drop_oldest"
+	OverflowSpill      = "spill"
+)
+
+const (
+	defaultQueueSize = 100
+	// queueInputBuffer smooths bursts between the sources and the goroutine
+	// applying the overflow policy
+	queueInputBuffer = 64
+	// maxSpillRecord bounds a record read back from a spill file, so a
+	// corrupt length does not cause a huge allocation
+	maxSpillRecord = 64 * 1024 * 1024
+	// defaultSpillMaxBytes bounds the size of a spill file
+	defaultSpillMaxBytes = 1 << 30
+)
+
+// QueueConfig represents the queue and workers of a destination
+type QueueConfig struct {
+	Size     int    `yaml:"size,omitempty"`
+	Workers  int    `yaml:"workers,omitempty"`
+	Overflow string `yaml:"overflow,omitempty"`
+	// SpillDir holds the spill file of the spill policy
+	SpillDir string `yaml:"spill_dir,omitempty"`
+	// SpillMaxBytes bounds the spill file; events that do not fit are
+	// dropped
+	SpillMaxBytes int64 `yaml:"spill_max_bytes,omitempty"`
+}
+
+// Queue buffers the events of one destination between the sources and
+// the workers delivering them. When it is full, events are handled by the
+// overflow policy: block waits for space, drop_newest discards the new
+// event, drop_oldest the oldest queued one, and spill appends events to a
+// file that is read back, in order, as space frees up. A full spill file
+// drops new events like drop_newest.
+type Queue struct {
+	id       string
+	size     int
+	workers  int
+	overflow string
+	in       chan *Event
+	log      *slog.Logger
+
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	items    []*Event
+	spill    *spillFile
+	stopping bool
+
+	// done stops the goroutine reading in; input and workers finish once
+	// it and the workers returned
+	done     chan struct{}
+	stopOnce sync.Once
+	input    sync.WaitGroup
+	running  sync.WaitGroup
+}
+
+// NewQueue creates the queue of destination id described by cfg, which
+// may be nil. Its depth is exported as queue.<id>.depth.
+func NewQueue(id string, cfg *QueueConfig) (*Queue, error) {
+	if cfg == nil {
+		cfg = &QueueConfig{}
+	}
+	q := &Queue{
+		id:       id,
+		size:     cfg.Size,
+		workers:  cfg.Workers,
+		overflow: cfg.Overflow,
+		in:       make(chan *Event, queueInputBuffer),
+		log:      ComponentLogger("queue", "destination", id),
+		done:     make(chan struct{}),
+	}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	if q.size <= 0 {
+		q.size = defaultQueueSize
+	}
+	if q.workers <= 0 {
+		q.workers = 1
+	}
+
+	switch q.overflow {
+	case "":
+		q.overflow = OverflowBlock
+	case OverflowBlock, OverflowDropNewest, OverflowDropOldest:
+	case OverflowSpill:
+		if cfg.SpillDir == "" {
+			return nil, fmt.Errorf("spill overflow requires spill_dir")
+		}
+		maxBytes := cfg.SpillMaxBytes
+		if maxBytes <= 0 {
+			maxBytes = defaultSpillMaxBytes
+		}
+		spill, err := openSpillFile(filepath.Join(cfg.SpillDir, id+".spill"), maxBytes)
+		if err != nil {
+			return nil, err
+		}
+		q.spill = spill
+		Metrics.Set("queue."+id+".spill_bytes", expvar.Func(func() any {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			return q.spill.Pending()
+		}))
+	default:
+		return nil, fmt.Errorf("unsupported overflow policy: %s", cfg.Overflow)
+	}
+
+	Metrics.Set("queue."+id+".depth", expvar.Func(func() any {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return len(q.items)
+	}))
+	return q, nil
+}
+
+// In returns the channel sources send events to.
+func (q *Queue) In() chan *Event {
+	return q.in
+}
+
+// Run starts the goroutine accepting events from In and the workers
+// passing queued events to deliver.
+func (q *Queue) Run(deliver func(*Event)) {
+	q.input.Add(1)
+	go func() {
+		defer q.input.Done()
+		for {
+			select {
+			case evt := <-q.in:
+				q.push(evt)
+			case <-q.done:
+				// Take the events sources sent before they were stopped
+				for {
+					select {
+					case evt := <-q.in:
+						q.push(evt)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+	for i := 0; i < q.workers; i++ {
+		q.running.Add(1)
+		go func() {
+			defer q.running.Done()
+			for {
+				evt, ok := q.pop()
+				if !ok {
+					return
+				}
+				deliver(evt)
+			}
+		}()
+	}
+}
+
+// Stop waits until the events queued in memory are delivered and stops the
+// workers. Spilled events stay in the spill file. Sources must be stopped
+// first.
+func (q *Queue) Stop() {
+	q.stopOnce.Do(func() {
+		close(q.done)
+		q.input.Wait()
+
+		q.mu.Lock()
+		q.stopping = true
+		q.notEmpty.Broadcast()
+		q.mu.Unlock()
+		q.running.Wait()
+	})
+}
+
+func (q *Queue) push(evt *Event) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// Once events are spilled, newer ones follow them to keep the order
+	if q.spill != nil && (len(q.items) >= q.size || q.spill.Pending() > 0) {
+		if err := q.spill.Write(evt); err != nil {
+			AddMetric("queue."+q.id+".dropped", 1)
+			if !errors.Is(err, errSpillFull) {
+				q.log.Error("Error spilling event", "error", err)
+			}
+			return
+		}
+		AddMetric("queue."+q.id+".spilled", 1)
+		return
+	}
+
+	for len(q.items) >= q.size {
+		switch q.overflow {
+		case OverflowDropNewest:
+			AddMetric("queue."+q.id+".dropped", 1)
+			return
+		case OverflowDropOldest:
+			q.items[0] = nil
+			q.items = q.items[1:]
+			AddMetric("queue."+q.id+".dropped", 1)
+		default:
+			q.notFull.Wait()
+		}
+	}
+	q.items = append(q.items, evt)
+	q.notEmpty.Signal()
+}
+
+// pop waits for the next event, refilling the queue from the spill file.
+// It returns false once the queue is stopping and empty.
+func (q *Queue) pop() (*Event, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) == 0 {
+		if q.stopping {
+			return nil, false
+		}
+		if q.spill != nil && q.spill.Pending() > 0 {
+			q.refill()
+			continue
+		}
+		q.notEmpty.Wait()
+	}
+	evt := q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+	if len(q.items) == 0 {
+		q.items = nil
+	}
+	if q.spill != nil && !q.stopping {
+		q.refill()
+	}
+	q.notFull.Signal()
+	return evt, true
+}
+
+// refill moves spilled events back into the queue while there is space.
+// Unreadable spill data is discarded.
+func (q *Queue) refill() {
+	for len(q.items) < q.size && q.spill.Pending() > 0 {
+		evt, err := q.spill.Read()
+		if err != nil {
+			q.log.Error("Error reading spilled events, discarding spill file", "error", err)
+			q.spill.Reset()
+			return
+		}
+		q.items = append(q.items, evt)
+	}
+}
+
+// Close stops the queue and closes the spill file. Spilled events that
+// were not delivered are read back when the queue is created again.
+func (q *Queue) Close() error {
+	q.Stop()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.spill == nil {
+		return nil
+	}
+	return q.spill.Close()
+}
+
+// spillRecord is the serialized form of a spilled event.
+type spillRecord struct {
+	Raw     string            `json:"raw"`
+	Topic   string            `json:"topic,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// errSpillFull is returned by Write when a record does not fit in the spill
+// file.
+var errSpillFull = errors.New("spill file is full")
+
+// spillFile is an append-only file of length-prefixed records that is read
+// from the start and truncated once everything was read. The records read
+// so far are removed when the file is closed, or when it reaches maxBytes,
+// so that they are not read again after a restart.
+type spillFile struct {
+	path     string
+	file     *os.File
+	maxBytes int64
+	readOff  int64
+	writeOff int64
+}
+
+// openSpillFile opens path, keeping records left by a previous run.
+func openSpillFile(path string, maxBytes int64) (*spillFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spill file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &spillFile{path: path, file: file, maxBytes: maxBytes, writeOff: info.Size()}, nil
+}
+
+// Pending returns the number of unread bytes.
+func (f *spillFile) Pending() int64 {
+	return f.writeOff - f.readOff
+}
+
+func (f *spillFile) Write(evt *Event) error {
+	raw, err := evt.Bytes()
+	if err != nil {
+		return err
+	}
+	record, err := sonic.Marshal(spillRecord{Raw: string(raw), Topic: evt.topic, Headers: evt.headers})
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4+len(record))
+	binary.BigEndian.PutUint32(buf, uint32(len(record)))
+	copy(buf[4:], record)
+	if f.writeOff+int64(len(buf)) > f.maxBytes && f.readOff > 0 {
+		if err := f.compact(); err != nil {
+			return err
+		}
+	}
+	if f.writeOff+int64(len(buf)) > f.maxBytes {
+		return errSpillFull
+	}
+	n, err := f.file.WriteAt(buf, f.writeOff)
+	f.writeOff += int64(n)
+	return err
+}
+
+func (f *spillFile) Read() (*Event, error) {
+	var size [4]byte
+	if _, err := f.file.ReadAt(size[:], f.readOff); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxSpillRecord || f.readOff+4+int64(n) > f.writeOff {
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, n)
+	if _, err := f.file.ReadAt(buf, f.readOff+4); err != nil {
+		return nil, err
+	}
+	f.readOff += 4 + int64(n)
+	if f.readOff == f.writeOff {
+		f.Reset()
+	}
+
+	var record spillRecord
+	if err := sonic.Unmarshal(buf, &record); err != nil {
+		return nil, err
+	}
+	evt := NewEvent([]byte(record.Raw))
+	evt.topic, evt.headers = record.Topic, record.Headers
+	return evt, nil
+}
+
+// Reset discards all records.
+func (f *spillFile) Reset() {
+	_ = f.file.Truncate(0)
+	f.readOff, f.writeOff = 0, 0
+}
+
+// compact replaces the file with a copy holding only the unread records.
+// The copy is renamed over the file, so a crash leaves either of them.
+func (f *spillFile) compact() error {
+	if f.Pending() == 0 {
+		f.Reset()
+		return nil
+	}
+	tmp, err := os.OpenFile(f.path+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to compact spill file: %w", err)
+	}
+	pending := f.Pending()
+	_, err = io.Copy(tmp, io.NewSectionReader(f.file, f.readOff, pending))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), f.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to compact spill file: %w", err)
+	}
+	f.file.Close()
+	f.file, f.readOff, f.writeOff = tmp, 0, pending
+	return nil
+}
+
+// Close compacts and closes the file. When compacting fails, the records
+// read so far are read again after a restart.
+func (f *spillFile) Close() error {
+	err := f.compact()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
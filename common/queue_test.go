@@ -0,0 +1,196 @@
+package common
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDelivery records delivered events, holding deliveries until released.
+type testDelivery struct {
+	release chan struct{}
+
+	mu     sync.Mutex
+	events []string
+}
+
+func (d *testDelivery) deliver(evt *Event) {
+	if d.release != nil {
+		<-d.release
+	}
+	raw, _ := evt.Bytes()
+	d.mu.Lock()
+	d.events = append(d.events, string(raw))
+	d.mu.Unlock()
+}
+
+func (d *testDelivery) Events() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string{}, d.events...)
+}
+
+func TestQueueOverflow(t *testing.T) {
+	tests := []struct {
+		overflow string
+		want     []string
+	}{
+		// One event is taken by the held worker, two fit in the queue
+		{OverflowDropNewest, []string{"0", "1", "2"}},
+		{OverflowDropOldest, []string{"0", "4", "5"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.overflow, func(t *testing.T) {
+			q, err := NewQueue("test_"+tt.overflow, &QueueConfig{Size: 2, Overflow: tt.overflow})
+			require.NoError(t, err)
+			d := &testDelivery{release: make(chan struct{})}
+			q.Run(d.deliver)
+
+			q.In() <- NewEvent([]byte("0"))
+			require.Eventually(t, func() bool {
+				q.mu.Lock()
+				defer q.mu.Unlock()
+				return len(q.items) == 0
+			}, time.Second, time.Millisecond)
+			for i := 1; i < 6; i++ {
+				q.In() <- NewEvent([]byte{byte('0' + i)})
+			}
+
+			close(d.release)
+			q.Stop()
+			assert.Equal(t, tt.want, d.Events())
+		})
+	}
+}
+
+func TestQueueStopDelivers(t *testing.T) {
+	q, err := NewQueue("test_stop", &QueueConfig{Size: 100, Workers: 3})
+	require.NoError(t, err)
+	d := &testDelivery{}
+	q.Run(d.deliver)
+
+	for i := 0; i < 50; i++ {
+		q.In() <- NewEventFromMap(map[string]interface{}{"n": i})
+	}
+	q.Stop()
+	q.Stop()
+	assert.Len(t, d.Events(), 50)
+
+	// Workers are gone, so nothing is delivered after Stop
+	select {
+	case q.In() <- NewEvent([]byte("late")):
+	default:
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.Len(t, d.Events(), 50)
+}
+
+func TestSpillFileResume(t *testing.T) {
+	tests := []struct {
+		name string
+		read int
+		want []string
+	}{
+		{"nothing read", 0, []string{"0", "1", "2", "3", "4"}},
+		{"partly read", 2, []string{"2", "3", "4"}},
+		{"everything read", 5, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.spill")
+			f, err := openSpillFile(path, defaultSpillMaxBytes)
+			require.NoError(t, err)
+			for i := 0; i < 5; i++ {
+				require.NoError(t, f.Write(NewEvent([]byte{byte('0' + i)})))
+			}
+			for i := 0; i < tt.read; i++ {
+				_, err := f.Read()
+				require.NoError(t, err)
+			}
+			require.NoError(t, f.Close())
+
+			f, err = openSpillFile(path, defaultSpillMaxBytes)
+			require.NoError(t, err)
+			defer f.Close()
+			var got []string
+			for f.Pending() > 0 {
+				evt, err := f.Read()
+				require.NoError(t, err)
+				raw, _ := evt.Bytes()
+				got = append(got, string(raw))
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSpillFileMaxBytes(t *testing.T) {
+	// Each record of a one byte event takes 15 bytes
+	f, err := openSpillFile(filepath.Join(t.TempDir(), "test.spill"), 40)
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, f.Write(NewEvent([]byte("0"))))
+	require.NoError(t, f.Write(NewEvent([]byte("1"))))
+	assert.ErrorIs(t, f.Write(NewEvent([]byte("2"))), errSpillFull)
+
+	// Reading frees space once the file is compacted
+	_, err = f.Read()
+	require.NoError(t, err)
+	require.NoError(t, f.Write(NewEvent([]byte("3"))))
+	for _, want := range []string{"1", "3"} {
+		evt, err := f.Read()
+		require.NoError(t, err)
+		raw, _ := evt.Bytes()
+		assert.Equal(t, want, string(raw))
+	}
+}
+
+func TestQueueSpillKeptOnClose(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewQueue("test_spill", &QueueConfig{Size: 1, Overflow: OverflowSpill, SpillDir: dir})
+	require.NoError(t, err)
+	d := &testDelivery{release: make(chan struct{})}
+	q.Run(d.deliver)
+
+	// The held worker takes 0, 1 is queued and the rest is spilled
+	q.In() <- NewEvent([]byte("0"))
+	require.Eventually(t, func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return len(q.items) == 0
+	}, time.Second, time.Millisecond)
+	for i := 1; i < 5; i++ {
+		q.In() <- NewEvent([]byte{byte('0' + i)})
+	}
+	require.Eventually(t, func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return q.spill.Pending() == 45
+	}, time.Second, time.Millisecond)
+
+	closed := make(chan error)
+	go func() { closed <- q.Close() }()
+	require.Eventually(t, func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return q.stopping
+	}, time.Second, time.Millisecond)
+	close(d.release)
+	require.NoError(t, <-closed)
+	assert.Equal(t, []string{"0", "1"}, d.Events())
+
+	q, err = NewQueue("test_spill", &QueueConfig{Size: 1, Overflow: OverflowSpill, SpillDir: dir})
+	require.NoError(t, err)
+	d = &testDelivery{}
+	q.Run(d.deliver)
+	require.Eventually(t, func() bool { return len(d.Events()) == 3 }, time.Second, time.Millisecond)
+	require.NoError(t, q.Close())
+	assert.Equal(t, []string{"2", "3", "4"}, d.Events())
+}
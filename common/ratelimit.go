@@ -0,0 +1,215 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Rate limit actions
+const (
+	RateLimitDrop   = "drop"
+	RateLimitReject = "reject"
+	RateLimitTag    = "tag"
+)
+
+// RateConfig represents a token bucket
+type RateConfig struct {
+	PerSecond float64 `yaml:"per_second"`
+	Burst     int     `yaml:"burst,omitempty"`
+}
+
+// RateLimitConfig represents the rate limits of a listener
+type RateLimitConfig struct {
+	Listener *RateConfig `yaml:"listener,omitempty"`
+	IP       *RateConfig `yaml:"ip,omitempty"`
+	Token    *RateConfig `yaml:"token,omitempty"`
+	// Action is drop, reject or tag. Webhooks answer HTTP 429 for reject and
+	// acknowledge dropped payloads as usual; syslog drops the message for
+	// both.
+	Action string `yaml:"action,omitempty"`
+	// Tag is added to the tags field of limited events when Action is tag
+	Tag string `yaml:"tag,omitempty"`
+}
+
+// RateLimiter applies listener wide, per remote IP and per auth token
+// token buckets.
+type RateLimiter struct {
+	name     string
+	listener *keyedLimiter
+	ip       *keyedLimiter
+	token    *keyedLimiter
+	action   string
+	tag      string
+}
+
+// NewRateLimiter creates the limiter described by cfg. name identifies the
+// listener in metrics.
+func NewRateLimiter(name string, cfg *RateLimitConfig) (*RateLimiter, error) {
+	r := &RateLimiter{
+		name:   metricKey(name),
+		action: cfg.Action,
+		tag:    cfg.Tag,
+	}
+	switch r.action {
+	case "":
+		r.action = RateLimitDrop
+	case RateLimitDrop, RateLimitReject, RateLimitTag:
+	default:
+		return nil, fmt.Errorf("unsupported rate limit action: %s", cfg.Action)
+	}
+	if r.tag == "" {
+		r.tag = "rate_limited"
+	}
+
+	for _, l := range []struct {
+		cfg *RateConfig
+		dst **keyedLimiter
+	}{
+		{cfg.Listener, &r.listener},
+		{cfg.IP, &r.ip},
+		{cfg.Token, &r.token},
+	} {
+		if l.cfg == nil {
+			continue
+		}
+		if l.cfg.PerSecond <= 0 {
+			return nil, fmt.Errorf("rate limit per_second must be positive")
+		}
+		*l.dst = newKeyedLimiter(l.cfg.PerSecond, l.cfg.Burst)
+	}
+	return r, nil
+}
+
+// Allow reports whether an event from ip carrying token (empty when not
+// applicable) is within all configured limits. Tokens are only taken when
+// every limit allows the event, so rejected events do not use up the
+// capacity of the other buckets. Limited events are counted. A nil limiter
+// allows everything.
+func (r *RateLimiter) Allow(ip, token string) bool {
+	if r == nil {
+		return true
+	}
+	now := time.Now()
+
+	// Lock in a fixed order so that concurrent calls cannot deadlock
+	var buckets []*tokenBucket
+	allowed := true
+	for _, l := range []struct {
+		limiter *keyedLimiter
+		key     string
+	}{
+		{r.listener, ""},
+		{r.ip, ip},
+		{r.token, token},
+	} {
+		if l.limiter == nil || (l.limiter == r.token && token == "") {
+			continue
+		}
+		l.limiter.mu.Lock()
+		defer l.limiter.mu.Unlock()
+		b := l.limiter.bucket(l.key, now)
+		if b.tokens < 1 {
+			allowed = false
+		}
+		buckets = append(buckets, b)
+	}
+
+	if !allowed {
+		AddMetric("ratelimit."+r.name+".limited", 1)
+		return false
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true
+}
+
+// Action returns the configured action for limited events.
+func (r *RateLimiter) Action() string {
+	return r.action
+}
+
+// Tag marks a limited event that is passed on.
+func (r *RateLimiter) Tag(data map[string]interface{}) {
+	AppendTags(data, []string{"tags"}, r.tag)
+}
+
+// maxLimiterKeys bounds the number of per-key buckets kept in memory.
+const maxLimiterKeys = 10000
+
+// tokenBucket allows rate events per second with bursts of up to burst.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// keyedLimiter keeps one token bucket per key.
+type keyedLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+func newKeyedLimiter(rate float64, burst int) *keyedLimiter {
+	if burst <= 0 {
+		burst = int(rate)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return &keyedLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes a token from the bucket of key and reports whether one was
+// available.
+func (l *keyedLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// bucket returns the bucket of key refilled up to now, creating it when
+// needed. The caller must hold l.mu.
+func (l *keyedLimiter) bucket(key string, now time.Time) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxLimiterKeys {
+			l.evict(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	return b
+}
+
+// evict drops buckets that have refilled completely, as they behave like
+// new ones, and resets the map if that is not enough.
+func (l *keyedLimiter) evict(now time.Time) {
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+	if len(l.buckets) >= maxLimiterKeys {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiterChecksAllLimitsFirst(t *testing.T) {
+	limiter, err := NewRateLimiter("0.0.0.0:514", &RateLimitConfig{
+		Listener: &RateConfig{PerSecond: 0.001, Burst: 3},
+		IP:       &RateConfig{PerSecond: 0.001, Burst: 1},
+	})
+	require.NoError(t, err)
+
+	assert.True(t, limiter.Allow("10.0.0.1", ""))
+	// A noisy IP over its own limit must not drain the listener bucket
+	for i := 0; i < 10; i++ {
+		assert.False(t, limiter.Allow("10.0.0.1", ""))
+	}
+	assert.True(t, limiter.Allow("10.0.0.2", ""))
+	assert.True(t, limiter.Allow("10.0.0.3", ""))
+	assert.False(t, limiter.Allow("10.0.0.4", ""))
+}
+
+func TestRateLimiterToken(t *testing.T) {
+	limiter, err := NewRateLimiter("http://:8080", &RateLimitConfig{
+		Token: &RateConfig{PerSecond: 0.001, Burst: 1},
+	})
+	require.NoError(t, err)
+
+	assert.True(t, limiter.Allow("10.0.0.1", "a"))
+	assert.False(t, limiter.Allow("10.0.0.2", "a"))
+	assert.True(t, limiter.Allow("10.0.0.1", "b"))
+	// Requests without a token are not limited by the token bucket
+	assert.True(t, limiter.Allow("10.0.0.1", ""))
+	assert.True(t, limiter.Allow("10.0.0.1", ""))
+}
+
+func TestRateLimiterMetricName(t *testing.T) {
+	limiter, err := NewRateLimiter("http://0.0.0.0:8080", &RateLimitConfig{IP: &RateConfig{PerSecond: 0.001, Burst: 1}})
+	require.NoError(t, err)
+	limiter.Allow("10.0.0.1", "")
+	limiter.Allow("10.0.0.1", "")
+	assert.NotNil(t, Metrics.Get("ratelimit.0_0_0_0_8080.limited"))
+}
+
+func TestNewRateLimiterErrors(t *testing.T) {
+	_, err := NewRateLimiter("x", &RateLimitConfig{Action: "block"})
+	assert.Error(t, err)
+	_, err = NewRateLimiter("x", &RateLimitConfig{IP: &RateConfig{}})
+	assert.Error(t, err)
+}
+
+func TestMetricKey(t *testing.T) {
+	for in, want := range map[string]string{
+		"0.0.0.0:514":          "0_0_0_0_514",
+		"http://:8080":         "_8080",
+		"https://[::1]:443":    "___1__443",
+		"local-sink_1":         "local-sink_1",
+		"/var/run/syslog.sock": "_var_run_syslog_sock",
+	} {
+		assert.Equal(t, want, metricKey(in), in)
+	}
+}
@@ -0,0 +1,195 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Redaction actions
+const (
+	RedactMask   = "mask"
+	RedactHash   = "hash"
+	RedactRemove = "remove"
+)
+
+// redactDetector finds sensitive values; validate, when set, filters out
+// regex matches that are not real secrets.
+type redactDetector struct {
+	re       *regexp.Regexp
+	validate func(string) bool
+}
+
+var redactDetectors = map[string]redactDetector{
+	"pan":     {regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), luhnValid},
+	"aws_key": {regexp.MustCompile(`\b(?:AKIA|ASIA|AROA|AIDA)[0-9A-Z]{16}\b`), nil},
+	"jwt":     {regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+`), nil},
+	"email":   {regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`), nil},
+	"ip":      {regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|\b[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}\b`), validIP},
+}
+
+// redactProcessor masks, hashes (HMAC-SHA256 keyed with the salt) or removes
+// sensitive data. With fields it works on those paths only, otherwise on
+// every string and number in the event. Without detectors or patterns, whole
+// field values are redacted; otherwise only the matching substrings are.
+func redactProcessor(cfg ProcessorConfig) (fieldFunc, error) {
+	action := cfg.Action
+	if action == "" {
+		action = RedactMask
+	}
+	if action != RedactMask && action != RedactHash && action != RedactRemove {
+		return nil, fmt.Errorf("unsupported action: %s", action)
+	}
+	// Unsalted hashes of emails or addresses are reversed with a dictionary
+	if action == RedactHash && cfg.Salt == "" {
+		return nil, fmt.Errorf("hash action requires a salt")
+	}
+
+	var detectors []redactDetector
+	for _, name := range cfg.Detectors {
+		d, ok := redactDetectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported detector: %s", name)
+		}
+		detectors = append(detectors, d)
+	}
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		detectors = append(detectors, redactDetector{re: re})
+	}
+
+	var paths [][]string
+	if cfg.Field != "" || len(cfg.Fields) > 0 {
+		paths, _ = fieldPaths(cfg)
+	} else if len(detectors) == 0 {
+		return nil, fmt.Errorf("fields, detectors or patterns are required")
+	}
+
+	replace := func(value string) string {
+		switch action {
+		case RedactHash:
+			mac := hmac.New(sha256.New, []byte(cfg.Salt))
+			mac.Write([]byte(value))
+			return hex.EncodeToString(mac.Sum(nil))
+		case RedactRemove:
+			return ""
+		default:
+			return strings.Repeat("*", len(value))
+		}
+	}
+
+	redactString := func(value string) (string, bool) {
+		changed := false
+		for _, d := range detectors {
+			value = d.re.ReplaceAllStringFunc(value, func(match string) string {
+				if d.validate != nil && !d.validate(match) {
+					return match
+				}
+				changed = true
+				AddMetric("redact.matches", 1)
+				return replace(match)
+			})
+		}
+		return value, changed
+	}
+
+	return func(data map[string]interface{}) (bool, error) {
+		if paths == nil {
+			_, changed := redactValue(data, redactString)
+			return changed, nil
+		}
+		changed := false
+		for _, path := range paths {
+			value, ok := GetField(data, path)
+			if !ok {
+				continue
+			}
+			if len(detectors) > 0 {
+				if res, ok := redactValue(value, redactString); ok {
+					SetField(data, path, res)
+					changed = true
+				}
+				continue
+			}
+			AddMetric("redact.matches", 1)
+			if action == RedactRemove {
+				DeleteField(data, path)
+			} else {
+				SetField(data, path, replace(AnyToString(value)))
+			}
+			changed = true
+		}
+		return changed, nil
+	}, nil
+}
+
+// redactValue applies fn to every string in value, descending into maps and
+// lists, and returns the updated value and whether anything was redacted.
+// Numbers are matched in their decimal form and become strings when
+// redacted, so a card number sent as a JSON number is caught too.
+func redactValue(value interface{}, fn func(string) (string, bool)) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		if res, changed := fn(v); changed {
+			return res, true
+		}
+		return v, false
+	case float64, int64, int:
+		if res, changed := fn(AnyToString(v)); changed {
+			return res, true
+		}
+		return v, false
+	case map[string]interface{}:
+		changed := false
+		for k, item := range v {
+			if res, ok := redactValue(item, fn); ok {
+				v[k] = res
+				changed = true
+			}
+		}
+		return v, changed
+	case []interface{}:
+		changed := false
+		for i, item := range v {
+			if res, ok := redactValue(item, fn); ok {
+				v[i] = res
+				changed = true
+			}
+		}
+		return v, changed
+	default:
+		return v, false
+	}
+}
+
+// luhnValid reports whether the digits in s form a valid card number.
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && n <= 19 && sum%10 == 0
+}
+
+func validIP(s string) bool {
+	return net.ParseIP(s) != nil
+}
@@ -0,0 +1,110 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactProcessor(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("alice@example.com"))
+	hashed := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name string
+		cfg  ProcessorConfig
+		in   string
+		want string
+	}{
+		{
+			name: "mask pan in string",
+			cfg:  ProcessorConfig{Type: "redact", Detectors: []string{"pan"}},
+			in:   `{"msg":"card 4111 1111 1111 1111 used","id":"1234567890123"}`,
+			want: `{"msg":"card ******************* used","id":"1234567890123"}`,
+		},
+		{
+			name: "mask pan stored as number",
+			cfg:  ProcessorConfig{Type: "redact", Detectors: []string{"pan"}},
+			in:   `{"card":4111111111111111,"nested":{"list":[5500000000000004,42]}}`,
+			want: `{"card":"****************","nested":{"list":["****************",42]}}`,
+		},
+		{
+			name: "hmac email",
+			cfg:  ProcessorConfig{Type: "redact", Detectors: []string{"email"}, Action: RedactHash, Salt: "secret"},
+			in:   `{"user":"alice@example.com"}`,
+			want: `{"user":"` + hashed + `"}`,
+		},
+		{
+			name: "remove pattern in field",
+			cfg:  ProcessorConfig{Type: "redact", Field: "msg", Patterns: []string{`token=\S+`}, Action: RedactRemove},
+			in:   `{"msg":"login token=abc ok","other":"token=keep"}`,
+			want: `{"msg":"login  ok","other":"token=keep"}`,
+		},
+		{
+			name: "whole field",
+			cfg:  ProcessorConfig{Type: "redact", Fields: []string{"password", "pin"}},
+			in:   `{"password":"hunter2","pin":1234}`,
+			want: `{"password":"*******","pin":"****"}`,
+		},
+		{
+			name: "remove whole field",
+			cfg:  ProcessorConfig{Type: "redact", Field: "password", Action: RedactRemove},
+			in:   `{"password":"hunter2","user":"bob"}`,
+			want: `{"user":"bob"}`,
+		},
+		{
+			name: "ip detector validates matches",
+			cfg:  ProcessorConfig{Type: "redact", Detectors: []string{"ip"}},
+			in:   `{"msg":"from 10.0.0.1 version 999.1.1.1"}`,
+			want: `{"msg":"from ******** version 999.1.1.1"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor, err := NewProcessor(tt.cfg)
+			require.NoError(t, err)
+			evt, err := ParseEvent([]byte(tt.in))
+			require.NoError(t, err)
+			events, err := processor.Process(evt)
+			require.NoError(t, err)
+			require.Len(t, events, 1)
+			raw, err := events[0].Bytes()
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(raw))
+		})
+	}
+}
+
+func TestLuhnValid(t *testing.T) {
+	assert.True(t, luhnValid("4111111111111111"))
+	assert.True(t, luhnValid("4111-1111-1111-1111"))
+	assert.False(t, luhnValid("4111111111111112"))
+	assert.False(t, luhnValid("123"))
+}
+
+func TestRedactProcessorConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  ProcessorConfig
+		err  bool
+	}{
+		{"hash with salt", ProcessorConfig{Type: "redact", Field: "user", Action: RedactHash, Salt: "secret"}, false},
+		{"hash without salt", ProcessorConfig{Type: "redact", Field: "user", Action: RedactHash}, true},
+		{"mask without salt", ProcessorConfig{Type: "redact", Field: "user"}, false},
+		{"unsupported action", ProcessorConfig{Type: "redact", Field: "user", Action: "encrypt"}, true},
+		{"nothing to redact", ProcessorConfig{Type: "redact"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewProcessor(tt.cfg)
+			assert.Equal(t, tt.err, err != nil, err)
+		})
+	}
+}
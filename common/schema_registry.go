@@ -0,0 +1,266 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+const (
+	defaultRegistryTimeout = 5 * time.Second
+	// registryMinBackoff and registryMaxBackoff bound the delay between
+	// failed schema id lookups
+	registryMinBackoff = time.Second
+	registryMaxBackoff = time.Minute
+)
+
+// errRegistryClosed is returned by Encode once the encoder is closed while
+// the schema id is still unknown.
+var errRegistryClosed = errors.New("schema registry encoder closed")
+
+// SchemaRegistryConfig represents a Confluent Schema Registry
+type SchemaRegistryConfig struct {
+	URL string `yaml:"url"`
+	// Subject defaults to <topic>-value
+	Subject  string `yaml:"subject,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// Register registers the schema when it is not in the subject yet;
+	// otherwise an existing version is only looked up
+	Register bool          `yaml:"register,omitempty"`
+	Timeout  time.Duration `yaml:"timeout,omitempty"`
+}
+
+// registryEncoder frames the output of encoder in the Confluent wire
+// format: a zero magic byte and the 4 byte schema id, followed by the
+// payload. The schema id is fetched on first use and cached.
+type registryEncoder struct {
+	encoder    Encoder
+	cfg        *SchemaRegistryConfig
+	subject    string
+	schema     string
+	schemaType string
+	client     *http.Client
+	minBackoff time.Duration
+	log        *slog.Logger
+
+	// fetch is the current schema id lookup, shared by the records waiting
+	// for it
+	mu    sync.Mutex
+	fetch *registryFetch
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newRegistryEncoder(encoder Encoder, cfg *SchemaRegistryConfig, schemaType, schema, topic string) (*registryEncoder, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("schema registry url is required")
+	}
+	subject := cfg.Subject
+	if subject == "" {
+		if topic == "" {
+			return nil, fmt.Errorf("schema registry subject is required")
+		}
+		subject = topic + "-value"
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultRegistryTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &registryEncoder{
+		encoder:    encoder,
+		cfg:        cfg,
+		subject:    subject,
+		schema:     schema,
+		schemaType: schemaType,
+		client:     &http.Client{Timeout: timeout},
+		minBackoff: registryMinBackoff,
+		log:        ComponentLogger("schema_registry", "url", cfg.URL, "subject", subject),
+		ctx:        ctx,
+		cancel:     cancel,
+	}, nil
+}
+
+func (e *registryEncoder) Encode(evt *Event) ([]byte, error) {
+	id, err := e.schemaID()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := e.encoder.Encode(evt)
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(msg[1:], id)
+	return append(msg, payload...), nil
+}
+
+// Close releases the callers waiting for the schema id and stops fetching
+// it.
+func (e *registryEncoder) Close() error {
+	e.cancel()
+	return nil
+}
+
+// registryFetch is a schema id lookup. done is closed once it either
+// found id or was rejected with err.
+type registryFetch struct {
+	done  chan struct{}
+	id    uint32
+	err   error
+	retry time.Time
+}
+
+// schemaID returns the cached schema id. Until it is known, callers wait for
+// a single goroutine fetching it, so that a registry outage holds records
+// back, leaving the queue policy to decide about them, instead of failing
+// them. A lookup the registry rejects fails the records instead, until it
+// is tried again after minBackoff.
+func (e *registryEncoder) schemaID() (uint32, error) {
+	e.mu.Lock()
+	f := e.fetch
+	if f == nil || f.expired(time.Now()) {
+		f = &registryFetch{done: make(chan struct{})}
+		e.fetch = f
+		go e.fetchLoop(f)
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.id, f.err
+	case <-e.ctx.Done():
+		return 0, errRegistryClosed
+	}
+}
+
+// expired reports whether f was rejected long enough ago to try again.
+func (f *registryFetch) expired(now time.Time) bool {
+	select {
+	case <-f.done:
+		return f.err != nil && now.After(f.retry)
+	default:
+		return false
+	}
+}
+
+// fetchLoop fetches the schema id into f, retrying transport errors and
+// server errors with exponential backoff, until it succeeds, the registry
+// rejects the request or the encoder is closed.
+func (e *registryEncoder) fetchLoop(f *registryFetch) {
+	backoff := e.minBackoff
+	for {
+		id, err := e.fetchID()
+		if err == nil {
+			f.id = id
+			close(f.done)
+			e.log.Info("Fetched schema id", "id", id)
+			return
+		}
+		if e.ctx.Err() != nil {
+			return
+		}
+		AddMetric("schema_registry.errors", 1)
+
+		var statusErr *registryStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode < http.StatusInternalServerError {
+			f.err = fmt.Errorf("schema registry: %w", err)
+			f.retry = time.Now().Add(e.minBackoff)
+			close(f.done)
+			e.log.Error("Schema id lookup rejected", "error", err)
+			return
+		}
+		e.log.Warn("Error fetching schema id, retrying", "error", err, "retry_in", backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-e.ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > registryMaxBackoff {
+			backoff = registryMaxBackoff
+		}
+	}
+}
+
+// fetchID registers the schema or looks up its version in the subject.
+func (e *registryEncoder) fetchID() (uint32, error) {
+	body := map[string]interface{}{"schema": e.schema}
+	if e.schemaType != "AVRO" {
+		body["schemaType"] = e.schemaType
+	}
+	payload, err := sonic.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+
+	endpoint := strings.TrimSuffix(e.cfg.URL, "/") + "/subjects/" + url.PathEscape(e.subject)
+	if e.cfg.Register {
+		endpoint += "/versions"
+	}
+	req, err := http.NewRequestWithContext(e.ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if e.cfg.Username != "" {
+		req.SetBasicAuth(e.cfg.Username, e.cfg.Password)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+
+	var result struct {
+		ID        uint32 `json:"id"`
+		ErrorCode int    `json:"error_code"`
+		Message   string `json:"message"`
+	}
+	_ = sonic.Unmarshal(respBody, &result)
+	if resp.StatusCode != http.StatusOK {
+		return 0, &registryStatusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			ErrorCode:  result.ErrorCode,
+			Message:    result.Message,
+		}
+	}
+	if result.ID == 0 {
+		return 0, fmt.Errorf("response has no schema id")
+	}
+	return result.ID, nil
+}
+
+// registryStatusError is a registry response other than 200 OK.
+type registryStatusError struct {
+	StatusCode int
+	Status     string
+	ErrorCode  int
+	Message    string
+}
+
+func (e *registryStatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s: %s (error code %d)", e.Status, e.Message, e.ErrorCode)
+	}
+	return e.Status
+}
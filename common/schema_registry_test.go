@@ -0,0 +1,205 @@
+package common
+
+import (
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRegistry is a Schema Registry stand-in failing the first failures
+// requests with status.
+type testRegistry struct {
+	*httptest.Server
+	failures int32
+	status   int
+	requests int32
+	release  chan struct{}
+
+	mu   sync.Mutex
+	path string
+	body map[string]interface{}
+	user string
+}
+
+func newTestRegistry(t *testing.T, failures int32) *testRegistry {
+	r := &testRegistry{failures: failures, status: http.StatusServiceUnavailable}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&r.requests, 1)
+		if r.release != nil {
+			<-r.release
+		}
+		raw, _ := io.ReadAll(req.Body)
+		user, _, _ := req.BasicAuth()
+		r.mu.Lock()
+		r.path, r.user = req.URL.Path, user
+		r.body = nil
+		_ = sonic.Unmarshal(raw, &r.body)
+		r.mu.Unlock()
+
+		if n <= r.failures {
+			rw.WriteHeader(r.status)
+			if r.status == http.StatusNotFound {
+				rw.Write([]byte(`{"error_code":40401,"message":"Subject not found"}`))
+			}
+			return
+		}
+		rw.Write([]byte(`{"id":42}`))
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func newTestRegistryEncoder(t *testing.T, cfg *SchemaRegistryConfig, schemaType string) *registryEncoder {
+	t.Helper()
+	e, err := newRegistryEncoder(jsonEncoder{}, cfg, schemaType, `{"type":"object"}`, "logs")
+	require.NoError(t, err)
+	e.minBackoff = time.Millisecond
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func TestRegistryEncoder(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        SchemaRegistryConfig
+		schemaType string
+		path       string
+		body       map[string]interface{}
+		user       string
+	}{
+		{
+			name:       "lookup",
+			schemaType: "AVRO",
+			path:       "/subjects/logs-value",
+			body:       map[string]interface{}{"schema": `{"type":"object"}`},
+		},
+		{
+			name:       "register",
+			cfg:        SchemaRegistryConfig{Register: true, Subject: "custom", Username: "user", Password: "secret"},
+			schemaType: "JSON",
+			path:       "/subjects/custom/versions",
+			body:       map[string]interface{}{"schema": `{"type":"object"}`, "schemaType": "JSON"},
+			user:       "user",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := newTestRegistry(t, 0)
+			tt.cfg.URL = registry.URL + "/"
+			e := newTestRegistryEncoder(t, &tt.cfg, tt.schemaType)
+
+			for i := 0; i < 3; i++ {
+				msg, err := e.Encode(NewEventFromMap(map[string]interface{}{"a": 1}))
+				require.NoError(t, err)
+				assert.Equal(t, byte(0), msg[0])
+				assert.Equal(t, uint32(42), binary.BigEndian.Uint32(msg[1:5]))
+				assert.JSONEq(t, `{"a":1}`, string(msg[5:]))
+			}
+			assert.Equal(t, int32(1), atomic.LoadInt32(&registry.requests))
+			assert.Equal(t, tt.path, registry.path)
+			assert.Equal(t, tt.body, registry.body)
+			assert.Equal(t, tt.user, registry.user)
+		})
+	}
+}
+
+func TestRegistryEncoderRetries(t *testing.T) {
+	registry := newTestRegistry(t, 3)
+	e := newTestRegistryEncoder(t, &SchemaRegistryConfig{URL: registry.URL}, "AVRO")
+
+	// Records wait for the lookup instead of failing
+	msg, err := e.Encode(NewEventFromMap(map[string]interface{}{}))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(42), binary.BigEndian.Uint32(msg[1:5]))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&registry.requests))
+}
+
+func TestRegistryEncoderRejected(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			registry := newTestRegistry(t, 1)
+			registry.status = status
+			e := newTestRegistryEncoder(t, &SchemaRegistryConfig{URL: registry.URL}, "AVRO")
+			e.minBackoff = 50 * time.Millisecond
+
+			// Rejected lookups fail the record instead of retrying
+			_, err := e.Encode(NewEventFromMap(map[string]interface{}{}))
+			var statusErr *registryStatusError
+			require.ErrorAs(t, err, &statusErr)
+			assert.Equal(t, status, statusErr.StatusCode)
+			_, err = e.Encode(NewEventFromMap(map[string]interface{}{}))
+			assert.Error(t, err)
+			assert.Equal(t, int32(1), atomic.LoadInt32(&registry.requests))
+
+			// and are tried again after the backoff
+			time.Sleep(60 * time.Millisecond)
+			msg, err := e.Encode(NewEventFromMap(map[string]interface{}{}))
+			require.NoError(t, err)
+			assert.Equal(t, uint32(42), binary.BigEndian.Uint32(msg[1:5]))
+			assert.Equal(t, int32(2), atomic.LoadInt32(&registry.requests))
+		})
+	}
+}
+
+func TestRegistryEncoderSharesLookup(t *testing.T) {
+	registry := newTestRegistry(t, 0)
+	registry.release = make(chan struct{})
+	e := newTestRegistryEncoder(t, &SchemaRegistryConfig{URL: registry.URL}, "AVRO")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := e.Encode(NewEventFromMap(map[string]interface{}{}))
+			assert.NoError(t, err)
+		}()
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&registry.requests) == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(registry.release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&registry.requests))
+}
+
+func TestRegistryEncoderClose(t *testing.T) {
+	registry := newTestRegistry(t, 1<<30)
+	e := newTestRegistryEncoder(t, &SchemaRegistryConfig{URL: registry.URL}, "AVRO")
+
+	errs := make(chan error)
+	go func() {
+		_, err := e.Encode(NewEventFromMap(map[string]interface{}{}))
+		errs <- err
+	}()
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&registry.requests) > 1 }, time.Second, time.Millisecond)
+	e.Close()
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, errRegistryClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Encode still waiting after Close")
+	}
+}
+
+func TestNewRegistryEncoderErrors(t *testing.T) {
+	for _, tt := range []struct {
+		cfg   SchemaRegistryConfig
+		topic string
+	}{
+		{SchemaRegistryConfig{}, "logs"},
+		{SchemaRegistryConfig{URL: "http://localhost:8081"}, ""},
+	} {
+		_, err := newRegistryEncoder(jsonEncoder{}, &tt.cfg, "JSON", "{}", tt.topic)
+		assert.Error(t, err)
+	}
+}
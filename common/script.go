@@ -0,0 +1,199 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+const defaultScriptTimeout = 100 * time.Millisecond
+
+// scriptProcessor runs a Starlark function on every event. The script must
+// define process(event), which receives the event as a dict and returns a
+// dict to forward, a list of dicts to split the event, or None to drop it.
+type scriptProcessor struct {
+	name    string
+	metric  string
+	fn      starlark.Callable
+	timeout time.Duration
+}
+
+func newScriptProcessor(cfg ProcessorConfig) (*scriptProcessor, error) {
+	// A nil src makes Starlark read the file itself
+	var src interface{}
+	filename := cfg.Script
+	switch {
+	case cfg.Script != "" && cfg.Source != "":
+		return nil, fmt.Errorf("script and source are mutually exclusive")
+	case cfg.Source != "":
+		src = cfg.Source
+		filename = "inline.star"
+	case cfg.Script == "":
+		return nil, fmt.Errorf("script or source is required")
+	}
+
+	name := cfg.Name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+
+	// While loops and recursion are safe to allow as every call has a timeout
+	opts := &syntax.FileOptions{While: true, Recursion: true, Set: true}
+	thread := &starlark.Thread{Name: name}
+	globals, err := starlark.ExecFileOptions(opts, thread, filename, src, nil)
+	if err != nil {
+		return nil, fmt.Errorf("script %s: %w", name, err)
+	}
+	fn, ok := globals["process"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("script %s: process(event) function is not defined", name)
+	}
+	// Frozen globals can be shared by concurrent calls
+	globals.Freeze()
+
+	p := &scriptProcessor{
+		name:    name,
+		metric:  "script." + metricKey(name),
+		fn:      fn,
+		timeout: cfg.Timeout,
+	}
+	if p.timeout <= 0 {
+		p.timeout = defaultScriptTimeout
+	}
+	return p, nil
+}
+
+func (p *scriptProcessor) Process(evt *Event) ([]*Event, error) {
+	data, err := evt.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	AddMetric(p.metric+".calls", 1)
+	start := time.Now()
+
+	var timedOut atomic.Bool
+	thread := &starlark.Thread{Name: p.name}
+	timer := time.AfterFunc(p.timeout, func() {
+		timedOut.Store(true)
+		thread.Cancel("timeout")
+	})
+	res, err := starlark.Call(thread, p.fn, starlark.Tuple{toStarlark(data)}, nil)
+	timer.Stop()
+
+	AddMetric(p.metric+".duration_us", time.Since(start).Microseconds())
+	if err != nil {
+		if timedOut.Load() {
+			AddMetric(p.metric+".timeouts", 1)
+			return nil, fmt.Errorf("script %s timed out after %s", p.name, p.timeout)
+		}
+		AddMetric(p.metric+".errors", 1)
+		return nil, fmt.Errorf("script %s: %w", p.name, err)
+	}
+
+	var events []*Event
+	switch value := res.(type) {
+	case starlark.NoneType:
+	case *starlark.Dict:
+		events = append(events, NewEventFromMap(fromStarlark(value).(map[string]interface{})))
+	case *starlark.List:
+		for i := 0; i < value.Len(); i++ {
+			dict, ok := value.Index(i).(*starlark.Dict)
+			if !ok {
+				AddMetric(p.metric+".errors", 1)
+				return nil, fmt.Errorf("script %s: returned a list containing %s", p.name, value.Index(i).Type())
+			}
+			events = append(events, NewEventFromMap(fromStarlark(dict).(map[string]interface{})))
+		}
+	default:
+		AddMetric(p.metric+".errors", 1)
+		return nil, fmt.Errorf("script %s: returned %s, want dict, list or None", p.name, res.Type())
+	}
+
+	if len(events) == 0 {
+		AddMetric(p.metric+".dropped", 1)
+	}
+	return events, nil
+}
+
+// toStarlark converts decoded JSON values to Starlark values. Integral
+// numbers within the int64 range become ints so scripts can use them as
+// such; other numbers stay floats.
+func toStarlark(v interface{}) starlark.Value {
+	switch value := v.(type) {
+	case nil:
+		return starlark.None
+	case bool:
+		return starlark.Bool(value)
+	case string:
+		return starlark.String(value)
+	case int:
+		return starlark.MakeInt(value)
+	case int64:
+		return starlark.MakeInt64(value)
+	case float64:
+		// float64(math.MaxInt64) rounds up to 2^63, which is out of range
+		if value == math.Trunc(value) && value >= math.MinInt64 && value < math.MaxInt64 {
+			return starlark.MakeInt64(int64(value))
+		}
+		return starlark.Float(value)
+	case []interface{}:
+		list := make([]starlark.Value, 0, len(value))
+		for _, item := range value {
+			list = append(list, toStarlark(item))
+		}
+		return starlark.NewList(list)
+	case map[string]interface{}:
+		dict := starlark.NewDict(len(value))
+		for k, item := range value {
+			_ = dict.SetKey(starlark.String(k), toStarlark(item))
+		}
+		return dict
+	default:
+		return starlark.String(AnyToString(value))
+	}
+}
+
+// fromStarlark converts Starlark values back to JSON compatible values.
+func fromStarlark(v starlark.Value) interface{} {
+	switch value := v.(type) {
+	case starlark.NoneType:
+		return nil
+	case starlark.Bool:
+		return bool(value)
+	case starlark.String:
+		return string(value)
+	case starlark.Int:
+		if i, ok := value.Int64(); ok {
+			return i
+		}
+		return value.String()
+	case starlark.Float:
+		return float64(value)
+	case starlark.Indexable:
+		list := make([]interface{}, 0, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			list = append(list, fromStarlark(value.Index(i)))
+		}
+		return list
+	case *starlark.Dict:
+		res := make(map[string]interface{}, value.Len())
+		for _, item := range value.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				res[item[0].String()] = fromStarlark(item[1])
+				continue
+			}
+			res[string(key)] = fromStarlark(item[1])
+		}
+		return res
+	default:
+		return value.String()
+	}
+}
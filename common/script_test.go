@@ -0,0 +1,174 @@
+package common
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+)
+
+func TestScriptProcessor(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		in     string
+		want   []string
+		err    string
+	}{
+		{
+			name: "modify",
+			source: `
+def process(event):
+    event["count"] = event["count"] + 1
+    event["user"] = event["user"].upper()
+    return event
+`,
+			in:   `{"count":41,"user":"alice"}`,
+			want: []string{`{"count":42,"user":"ALICE"}`},
+		},
+		{
+			name: "drop",
+			source: `
+def process(event):
+    if event["level"] == "debug":
+        return None
+    return event
+`,
+			in: `{"level":"debug"}`,
+		},
+		{
+			name: "split",
+			source: `
+def process(event):
+    return [{"item": i} for i in event["items"]]
+`,
+			in:   `{"items":[1,2]}`,
+			want: []string{`{"item":1}`, `{"item":2}`},
+		},
+		{
+			name: "large and fractional numbers stay floats",
+			source: `
+def process(event):
+    event["types"] = [type(event["big"]), type(event["small"]), type(event["frac"]), type(event["int"])]
+    return event
+`,
+			in:   `{"big":1e300,"small":-1e19,"frac":1.5,"int":3}`,
+			want: []string{`{"big":1e300,"small":-1e19,"frac":1.5,"int":3,"types":["float","float","float","int"]}`},
+		},
+		{
+			name: "runtime error",
+			source: `
+def process(event):
+    return event["missing"]
+`,
+			in:  `{}`,
+			err: "script inline: ",
+		},
+		{
+			name: "invalid result",
+			source: `
+def process(event):
+    return "nope"
+`,
+			in:  `{}`,
+			err: "script inline: returned string, want dict, list or None",
+		},
+		{
+			name: "invalid list item",
+			source: `
+def process(event):
+    return [event, 1]
+`,
+			in:  `{}`,
+			err: "script inline: returned a list containing int",
+		},
+		{
+			name: "timeout",
+			source: `
+def process(event):
+    while True:
+        pass
+`,
+			in:  `{}`,
+			err: "script inline timed out after 20ms",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor, err := NewProcessor(ProcessorConfig{Type: "script", Name: "inline", Source: tt.source, Timeout: 20 * time.Millisecond})
+			require.NoError(t, err)
+			evt, err := ParseEvent([]byte(tt.in))
+			require.NoError(t, err)
+
+			events, err := processor.Process(evt)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, events, len(tt.want))
+			for i, e := range events {
+				raw, err := e.Bytes()
+				require.NoError(t, err)
+				assert.JSONEq(t, tt.want[i], string(raw))
+			}
+		})
+	}
+}
+
+func TestScriptProcessorConfig(t *testing.T) {
+	for _, cfg := range []ProcessorConfig{
+		{Type: "script"},
+		{Type: "script", Script: "a.star", Source: "def process(e): return e"},
+		{Type: "script", Source: "x = 1"},
+		{Type: "script", Source: "syntax error("},
+	} {
+		_, err := NewProcessor(cfg)
+		assert.Error(t, err, cfg.Source)
+	}
+}
+
+func TestToStarlarkNumbers(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want starlark.Value
+	}{
+		{42, starlark.MakeInt64(42)},
+		{-7, starlark.MakeInt64(-7)},
+		{1.5, starlark.Float(1.5)},
+		{math.MinInt64, starlark.MakeInt64(math.MinInt64)},
+		{math.MaxInt64, starlark.Float(math.MaxInt64)},
+		{1e19, starlark.Float(1e19)},
+		{-1e19, starlark.Float(-1e19)},
+		{math.Inf(1), starlark.Float(math.Inf(1))},
+	}
+	for _, tt := range tests {
+		got := toStarlark(tt.in)
+		assert.Equal(t, tt.want.Type(), got.Type(), tt.in)
+		assert.Equal(t, tt.want.String(), got.String(), tt.in)
+	}
+}
+
+func TestScriptProcessorMetricName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"enrich", "script.enrich.calls"},
+		{"my.script v2", "script.my_script_v2.calls"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor, err := NewProcessor(ProcessorConfig{Type: "script", Name: tt.name, Source: "def process(e): return e"})
+			require.NoError(t, err)
+			_, err = processor.Process(NewEventFromMap(map[string]interface{}{"n": 1}))
+			require.NoError(t, err)
+			assert.NotNil(t, Metrics.Get(tt.want))
+		})
+	}
+}
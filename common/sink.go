@@ -0,0 +1,178 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Sink types
+const (
+	SinkFile   = "file"
+	SinkStdout = "stdout"
+	SinkHTTP   = "http"
+	SinkSyslog = "syslog"
+)
+
+const (
+	defaultFileMaxSize  = 100 * 1024 * 1024
+	defaultFileMaxFiles = 5
+)
+
+// Sink delivers events to a destination. KafkaProducer is the Kafka sink.
+type Sink interface {
+	SendMessage(evt *Event) error
+	Close() error
+}
+
+// SinkConfig represents a destination other than Kafka
+type SinkConfig struct {
+	Type string `yaml:"type"`
+
+	// File sink options; the file is rotated to <path>.1 ... <path>.<max_files>
+	// when it exceeds max_size bytes
+	Path     string `yaml:"path,omitempty"`
+	MaxSize  int64  `yaml:"max_size,omitempty"`
+	MaxFiles int    `yaml:"max_files,omitempty"`
+
+	// HTTP sink options; events are posted as a JSON array of up to
+	// batch_size events, at least every flush_interval
+	URL           string            `yaml:"url,omitempty"`
+	Headers       map[string]string `yaml:"headers,omitempty"`
+	BatchSize     int               `yaml:"batch_size,omitempty"`
+	FlushInterval time.Duration     `yaml:"flush_interval,omitempty"`
+	Timeout       time.Duration     `yaml:"timeout,omitempty"`
+
+	// Syslog sink options
+	Address    string           `yaml:"address,omitempty"`
+	Protocol   string           `yaml:"protocol,omitempty"`
+	Format     string           `yaml:"format,omitempty"`
+	Framing    string           `yaml:"framing,omitempty"`
+	BufferSize int              `yaml:"buffer_size,omitempty"`
+	TLS        *ClientTLSConfig `yaml:"tls,omitempty"`
+}
+
+// NewSink creates the sink described by cfg. name identifies it in logs.
+func NewSink(name string, cfg *SinkConfig) (Sink, error) {
+	switch cfg.Type {
+	case SinkFile:
+		return newFileSink(cfg.Path, cfg.MaxSize, cfg.MaxFiles)
+	case SinkStdout:
+		return newWriterSink(os.Stdout), nil
+	case SinkHTTP:
+		return newHTTPSink(name, cfg)
+	case SinkSyslog:
+		return newSyslogSink(name, cfg)
+	default:
+		return nil, fmt.Errorf("unsupported sink type: %s", cfg.Type)
+	}
+}
+
+// writerSink writes events as JSON lines.
+type writerSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newWriterSink(w io.Writer) *writerSink {
+	return &writerSink{w: w}
+}
+
+func (s *writerSink) SendMessage(evt *Event) error {
+	msg, err := evt.Bytes()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(msg[:len(msg):len(msg)], '\n'))
+	return err
+}
+
+func (s *writerSink) Close() error {
+	return nil
+}
+
+// fileSink writes events as JSON lines to a file, rotating it by size.
+type fileSink struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func newFileSink(path string, maxSize int64, maxFiles int) (*fileSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file sink requires a path")
+	}
+	if maxSize <= 0 {
+		maxSize = defaultFileMaxSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = defaultFileMaxFiles
+	}
+	s := &fileSink{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", s.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+// rotate shifts <path>.N to <path>.N+1, dropping the oldest file, and
+// starts a new file.
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	for i := s.maxFiles - 1; i > 0; i-- {
+		_ = os.Rename(s.path+"."+strconv.Itoa(i), s.path+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileSink) SendMessage(evt *Event) error {
+	msg, err := evt.Bytes()
+	if err != nil {
+		return err
+	}
+	line := append(msg[:len(msg):len(msg)], '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate %s: %w", s.path, err)
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
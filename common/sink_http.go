@@ -0,0 +1,162 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHTTPBatchSize     = 100
+	defaultHTTPFlushInterval = time.Second
+	defaultHTTPTimeout       = 10 * time.Second
+)
+
+// httpSink posts events in batches as JSON arrays. A batch is sent when it
+// is full, when flushInterval has passed and on Close. Events are added to
+// the next batch while one is posted, and batches are posted one at a time
+// to keep their order.
+type httpSink struct {
+	url       string
+	headers   map[string]string
+	batchSize int
+	client    *http.Client
+	metric    string
+	log       *slog.Logger
+
+	mu     sync.Mutex
+	batch  [][]byte
+	postMu sync.Mutex
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newHTTPSink(name string, cfg *SinkConfig) (*httpSink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("http sink requires a url")
+	}
+	s := &httpSink{
+		url:       cfg.URL,
+		headers:   cfg.Headers,
+		batchSize: cfg.BatchSize,
+		client:    &http.Client{Timeout: cfg.Timeout},
+		metric:    "sink." + name,
+		log:       ComponentLogger("sink", "sink_id", name),
+		done:      make(chan struct{}),
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = defaultHTTPBatchSize
+	}
+	if s.client.Timeout <= 0 {
+		s.client.Timeout = defaultHTTPTimeout
+	}
+	interval := cfg.FlushInterval
+	if interval <= 0 {
+		interval = defaultHTTPFlushInterval
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.flush(false); err != nil {
+					s.log.Error("Error sending batch", "error", err)
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+func (s *httpSink) SendMessage(evt *Event) error {
+	msg, err := evt.Bytes()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.batch = append(s.batch, msg)
+	full := len(s.batch) >= s.batchSize
+	s.mu.Unlock()
+	if full {
+		return s.flush(true)
+	}
+	return nil
+}
+
+// flush posts the pending batch, or only a full one when full is set. The
+// batch is taken while holding postMu so that batches are posted in order.
+func (s *httpSink) flush(full bool) error {
+	s.postMu.Lock()
+	defer s.postMu.Unlock()
+	return s.post(s.take(full))
+}
+
+// take removes and returns the pending batch, or only a full one when
+// full is set.
+func (s *httpSink) take(full bool) [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.batch) == 0 || (full && len(s.batch) < s.batchSize) {
+		return nil
+	}
+	batch := s.batch
+	s.batch = nil
+	return batch
+}
+
+// post sends batch. The batch is discarded even when the request fails. It
+// must be called with postMu held.
+func (s *httpSink) post(batch [][]byte) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	body.WriteByte('[')
+	for i, msg := range batch {
+		if i > 0 {
+			body.WriteByte(',')
+		}
+		body.Write(msg)
+	}
+	body.WriteByte(']')
+	count := len(batch)
+
+	req, err := http.NewRequest(http.MethodPost, s.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		AddMetric(s.metric+".dropped", int64(count))
+		return fmt.Errorf("failed to post %d events: %w", count, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		AddMetric(s.metric+".dropped", int64(count))
+		return fmt.Errorf("failed to post %d events: %s", count, resp.Status)
+	}
+	return nil
+}
+
+// Close stops the flush timer and sends the pending batch.
+func (s *httpSink) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	return s.flush(false)
+}
@@ -0,0 +1,135 @@
+package common
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testHTTPReceiver records the bodies posted to it.
+type testHTTPReceiver struct {
+	*httptest.Server
+	status  int
+	release chan struct{}
+
+	mu     sync.Mutex
+	bodies []string
+}
+
+func newTestHTTPReceiver(t *testing.T, status int) *testHTTPReceiver {
+	r := &testHTTPReceiver{status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.bodies = append(r.bodies, req.Header.Get("X-Token")+" "+string(body))
+		r.mu.Unlock()
+		if r.release != nil {
+			<-r.release
+		}
+		rw.WriteHeader(r.status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *testHTTPReceiver) Bodies() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.bodies)
+}
+
+func TestHTTPSink(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		events  int
+		want    []string
+		err     bool
+		dropped int64
+	}{
+		{"full batches and rest on close", http.StatusOK, 5, []string{`t [{"n":0},{"n":1}]`, `t [{"n":2},{"n":3}]`, `t [{"n":4}]`}, false, 0},
+		{"no events", http.StatusOK, 0, nil, false, 0},
+		{"failed post", http.StatusInternalServerError, 2, []string{`t [{"n":0},{"n":1}]`}, true, 2},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := fmt.Sprintf("http%d", i)
+			dropped := func() int64 {
+				if v, ok := Metrics.Get("sink." + id + ".dropped").(*expvar.Int); ok {
+					return v.Value()
+				}
+				return 0
+			}
+			before := dropped()
+
+			receiver := newTestHTTPReceiver(t, tt.status)
+			sink, err := NewSink(id, &SinkConfig{
+				Type:          SinkHTTP,
+				URL:           receiver.URL,
+				Headers:       map[string]string{"X-Token": "t"},
+				BatchSize:     2,
+				FlushInterval: time.Hour,
+			})
+			require.NoError(t, err)
+
+			var sendErr error
+			for i := 0; i < tt.events; i++ {
+				if err := sink.SendMessage(NewEventFromMap(map[string]interface{}{"n": i})); err != nil {
+					sendErr = err
+				}
+			}
+			require.NoError(t, sink.Close())
+			assert.Equal(t, tt.err, sendErr != nil)
+			assert.Equal(t, tt.want, receiver.Bodies())
+			assert.Equal(t, tt.dropped, dropped()-before)
+		})
+	}
+}
+
+func TestHTTPSinkPostsOutsideLock(t *testing.T) {
+	receiver := newTestHTTPReceiver(t, http.StatusOK)
+	receiver.release = make(chan struct{})
+	sink, err := NewSink("test", &SinkConfig{Type: SinkHTTP, URL: receiver.URL, BatchSize: 2, FlushInterval: time.Hour})
+	require.NoError(t, err)
+
+	// The second event fills a batch whose post hangs
+	require.NoError(t, sink.SendMessage(NewEventFromMap(map[string]interface{}{"n": 0})))
+	go sink.SendMessage(NewEventFromMap(map[string]interface{}{"n": 1}))
+	require.Eventually(t, func() bool { return len(receiver.Bodies()) == 1 }, time.Second, time.Millisecond)
+
+	// Adding to the next batch does not wait for it
+	added := make(chan struct{})
+	go func() {
+		sink.SendMessage(NewEventFromMap(map[string]interface{}{"n": 2}))
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("SendMessage blocked by a pending post")
+	}
+
+	close(receiver.release)
+	require.NoError(t, sink.Close())
+	assert.Equal(t, []string{` [{"n":0},{"n":1}]`, ` [{"n":2}]`}, receiver.Bodies())
+}
+
+func TestHTTPSinkFlushInterval(t *testing.T) {
+	receiver := newTestHTTPReceiver(t, http.StatusOK)
+	sink, err := NewSink("test", &SinkConfig{Type: SinkHTTP, URL: receiver.URL, FlushInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	require.NoError(t, sink.SendMessage(NewEventFromMap(map[string]interface{}{"n": 0})))
+	assert.Eventually(t, func() bool { return len(receiver.Bodies()) == 1 }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,480 @@
+package common
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSyslogPriority is user.notice, used for events without a
+	// valid priority
+	defaultSyslogPriority = 13
+	maxSyslogPriority     = 191
+
+	defaultSyslogBufferSize = 10000
+	syslogDialTimeout       = 5 * time.Second
+	syslogWriteTimeout      = 10 * time.Second
+	syslogMaxBackoff        = 30 * time.Second
+	syslogCloseTimeout      = 5 * time.Second
+
+	// rfc5424TimeLayout limits TIME-SECFRAC to the 6 digits RFC5424 allows
+	rfc5424TimeLayout = "2006-01-02T15:04:05.000000Z07:00"
+	// sdNameMaxLen is the maximum length of an SD-ID or PARAM-NAME
+	sdNameMaxLen = 32
+
+	// Maximum lengths of the RFC5424 header fields
+	hostnameMaxLen = 255
+	appNameMaxLen  = 48
+	procIDMaxLen   = 128
+	msgIDMaxLen    = 32
+)
+
+// Syslog sink framings for stream protocols
+const (
+	FramingNewline       = "newline"
+	FramingOctetCounting = "octet_counting"
+)
+
+// ClientTLSConfig represents the TLS settings of an outgoing connection
+type ClientTLSConfig struct {
+	CAFile             string `yaml:"ca_file,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// newClientTLS builds the tls.Config described by cfg, which may be nil.
+func newClientTLS(cfg *ClientTLSConfig, address string) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	conf := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if cfg == nil {
+		return conf, nil
+	}
+	if cfg.ServerName != "" {
+		conf.ServerName = cfg.ServerName
+	}
+	conf.InsecureSkipVerify = cfg.InsecureSkipVerify
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// syslogSink relays events to a downstream syslog collector, rebuilding
+// RFC3164 or RFC5424 messages from the event fields. Messages are queued
+// and written by a background goroutine that reconnects with backoff, so
+// a collector outage only fills the buffer; when it is full the oldest
+// messages are dropped.
+type syslogSink struct {
+	address   string
+	protocol  string
+	format    string
+	framing   string
+	tlsConfig *tls.Config
+	hostname  string
+	metric    string
+	log       *slog.Logger
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    [][]byte
+	maxQueue int
+	closed   bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newSyslogSink(name string, cfg *SinkConfig) (*syslogSink, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("syslog sink requires an address")
+	}
+	s := &syslogSink{
+		address:  cfg.Address,
+		protocol: cfg.Protocol,
+		format:   cfg.Format,
+		framing:  cfg.Framing,
+		maxQueue: cfg.BufferSize,
+		metric:   "sink." + name,
+		log:      ComponentLogger("sink", "sink_id", name, "address", cfg.Address),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	switch s.protocol {
+	case "":
+		s.protocol = "udp"
+	case "udp", "tcp":
+	case "tls":
+		conf, err := newClientTLS(cfg.TLS, cfg.Address)
+		if err != nil {
+			return nil, err
+		}
+		s.tlsConfig = conf
+	default:
+		return nil, fmt.Errorf("unsupported syslog sink protocol: %s", s.protocol)
+	}
+	switch s.format {
+	case "":
+		s.format = "RFC5424"
+	case "RFC5424", "RFC3164":
+	default:
+		return nil, fmt.Errorf("unsupported syslog sink format: %s", s.format)
+	}
+	switch s.framing {
+	case "":
+		// RFC5425 requires octet counting over TLS
+		s.framing = FramingNewline
+		if s.protocol == "tls" {
+			s.framing = FramingOctetCounting
+		}
+	case FramingNewline, FramingOctetCounting:
+	default:
+		return nil, fmt.Errorf("unsupported syslog sink framing: %s", s.framing)
+	}
+	if s.maxQueue <= 0 {
+		s.maxQueue = defaultSyslogBufferSize
+	}
+	s.hostname, _ = os.Hostname()
+
+	go s.run()
+	return s, nil
+}
+
+// SendMessage queues evt for the writer. It only fails when the event
+// cannot be formatted.
+func (s *syslogSink) SendMessage(evt *Event) error {
+	var msg []byte
+	var err error
+	if s.format == "RFC3164" {
+		msg, err = s.formatRFC3164(evt)
+	} else {
+		msg, err = s.formatRFC5424(evt)
+	}
+	if err != nil {
+		return err
+	}
+	if s.protocol != "udp" {
+		if s.framing == FramingOctetCounting {
+			msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+		} else {
+			// An embedded newline would split the message, so it is escaped
+			// the way rsyslog escapes control characters
+			msg = append(bytes.ReplaceAll(msg, []byte("\n"), []byte("#012")), '\n')
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("syslog sink is closed")
+	}
+	if len(s.queue) >= s.maxQueue {
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		AddMetric(s.metric+".dropped", 1)
+	}
+	s.queue = append(s.queue, msg)
+	s.cond.Signal()
+	return nil
+}
+
+// next waits for a queued message. It returns false once the sink is
+// closed and the queue is empty.
+func (s *syslogSink) next() ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queue) == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if len(s.queue) == 0 {
+		return nil, false
+	}
+	msg := s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	if len(s.queue) == 0 {
+		s.queue = nil
+	}
+	return msg, true
+}
+
+func (s *syslogSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: syslogDialTimeout}
+	if s.tlsConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", s.address, s.tlsConfig)
+	}
+	return dialer.Dial(s.protocol, s.address)
+}
+
+// run writes queued messages, retrying a failed message on a new
+// connection after an increasing delay.
+func (s *syslogSink) run() {
+	defer close(s.done)
+
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	backoff := time.Second
+	for {
+		msg, ok := s.next()
+		if !ok {
+			return
+		}
+		for {
+			var err error
+			if conn == nil {
+				conn, err = s.dial()
+			}
+			if err == nil {
+				_ = conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+				if _, err = conn.Write(msg); err == nil {
+					backoff = time.Second
+					break
+				}
+				conn.Close()
+				conn = nil
+			}
+
+			AddMetric(s.metric+".errors", 1)
+			s.log.Error("Error sending to syslog collector", "error", err, "retry_in", backoff.String())
+			select {
+			case <-time.After(backoff):
+			case <-s.stop:
+				return
+			}
+			if backoff *= 2; backoff > syslogMaxBackoff {
+				backoff = syslogMaxBackoff
+			}
+		}
+	}
+}
+
+// Close sends the queued messages, giving up after syslogCloseTimeout.
+func (s *syslogSink) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-time.After(syslogCloseTimeout):
+		close(s.stop)
+		s.mu.Lock()
+		dropped := len(s.queue)
+		s.mu.Unlock()
+		return fmt.Errorf("gave up sending %d queued messages to %s", dropped, s.address)
+	}
+}
+
+// syslogHeader holds the fields a forwarded message is rebuilt from.
+// Syslog events keep their header fields and content; other events are
+// sent with their JSON as content.
+type syslogHeader struct {
+	priority  int
+	timestamp time.Time
+	hostname  string
+	appName   string
+	procID    string
+	msgID     string
+	sd        map[string]interface{}
+	content   string
+}
+
+func newSyslogHeader(evt *Event, hostname string) (*syslogHeader, error) {
+	data, _ := evt.Data()
+	field := func(name string) string {
+		v, _ := data[name].(string)
+		return v
+	}
+
+	h := &syslogHeader{
+		priority: defaultSyslogPriority,
+		hostname: hostname,
+		appName:  headerField(field("app_name"), appNameMaxLen),
+		procID:   headerField(field("proc_id"), procIDMaxLen),
+		msgID:    headerField(field("msg_id"), msgIDMaxLen),
+		content:  field("content"),
+	}
+	// Priorities outside 0..191 would yield an invalid facility
+	switch v := data["priority"].(type) {
+	case float64:
+		if v >= 0 && v <= maxSyslogPriority && v == float64(int(v)) {
+			h.priority = int(v)
+		}
+	case int:
+		if v >= 0 && v <= maxSyslogPriority {
+			h.priority = v
+		}
+	}
+	var err error
+	if h.timestamp, err = time.Parse(time.RFC3339Nano, field("timestamp")); err != nil {
+		h.timestamp = time.Now()
+	}
+	if v := field("hostname"); v != "" {
+		h.hostname = v
+	}
+	h.hostname = headerField(h.hostname, hostnameMaxLen)
+	h.sd, _ = data["structured_data"].(map[string]interface{})
+	if _, ok := data["content"]; !ok {
+		raw, err := evt.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		h.content = string(raw)
+	}
+	return h, nil
+}
+
+// headerField returns v with every character other than printable US-ASCII,
+// such as spaces, replaced with '_', truncated to maxLen characters.
+func headerField(v string, maxLen int) string {
+	var sb strings.Builder
+	for _, c := range v {
+		if sb.Len() == maxLen {
+			break
+		}
+		if c < 33 || c > 126 {
+			c = '_'
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// formatRFC5424 renders evt as an RFC5424 message.
+func (s *syslogSink) formatRFC5424(evt *Event) ([]byte, error) {
+	h, err := newSyslogHeader(evt, s.hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("<" + strconv.Itoa(h.priority) + ">1 " + h.timestamp.Format(rfc5424TimeLayout))
+	for _, v := range []string{h.hostname, h.appName, h.procID, h.msgID} {
+		if v == "" {
+			v = "-"
+		}
+		sb.WriteString(" " + v)
+	}
+	sb.WriteByte(' ')
+	if invalid := writeStructuredData(&sb, h.sd); invalid > 0 {
+		AddMetric(s.metric+".invalid_sd", int64(invalid))
+	}
+	if h.content != "" {
+		sb.WriteString(" " + h.content)
+	}
+	return []byte(sb.String()), nil
+}
+
+// writeStructuredData renders sd as RFC5424 structured data, with elements
+// and parameters sorted by name, or "-" when it is empty. Elements and
+// parameters whose names are not valid SD-NAMEs are left out; their number
+// is returned.
+func writeStructuredData(sb *strings.Builder, sd map[string]interface{}) int {
+	invalid := 0
+	ids := make([]string, 0, len(sd))
+	for id := range sd {
+		if !validSDName(id) {
+			invalid++
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		sb.WriteByte('-')
+		return invalid
+	}
+	sort.Strings(ids)
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+	for _, id := range ids {
+		sb.WriteString("[" + id)
+		params, _ := sd[id].(map[string]interface{})
+		names := make([]string, 0, len(params))
+		for name := range params {
+			if !validSDName(name) {
+				invalid++
+				continue
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sb.WriteString(" " + name + `="` + escaper.Replace(AnyToString(params[name])) + `"`)
+		}
+		sb.WriteByte(']')
+	}
+	return invalid
+}
+
+// validSDName reports whether name is an RFC5424 SD-NAME: 1 to 32
+// printable US-ASCII characters except '=', space, ']' and '"'.
+func validSDName(name string) bool {
+	if name == "" || len(name) > sdNameMaxLen {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c < 33 || c > 126 || c == '=' || c == ']' || c == '"' {
+			return false
+		}
+	}
+	return true
+}
+
+// formatRFC3164 renders evt as a BSD syslog message. The timestamp is in
+// the local time zone, as RFC3164 has none.
+func (s *syslogSink) formatRFC3164(evt *Event) ([]byte, error) {
+	h, err := newSyslogHeader(evt, s.hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("<" + strconv.Itoa(h.priority) + ">" + h.timestamp.Local().Format(time.Stamp))
+	if h.hostname != "" {
+		sb.WriteString(" " + h.hostname)
+	}
+	if h.appName != "" {
+		sb.WriteString(" " + h.appName)
+		if h.procID != "" {
+			sb.WriteString("[" + h.procID + "]")
+		}
+		sb.WriteByte(':')
+	}
+	sb.WriteString(" " + h.content)
+	return []byte(sb.String()), nil
+}
@@ -0,0 +1,224 @@
+package common
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatRFC5424(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string]interface{}
+		want    string
+		invalid int64
+	}{
+		{
+			name: "nanoseconds truncated to microseconds",
+			data: map[string]interface{}{"priority": 165, "timestamp": "2024-05-01T12:00:00.123456789Z", "hostname": "host", "app_name": "app", "content": "hi"},
+			want: "<165>1 2024-05-01T12:00:00.123456Z host app - - - hi",
+		},
+		{
+			name: "zone kept",
+			data: map[string]interface{}{"timestamp": "2024-05-01T12:00:00+02:00", "hostname": "host", "content": "hi"},
+			want: "<13>1 2024-05-01T12:00:00.000000+02:00 host - - - - hi",
+		},
+		{
+			name: "structured data sorted and escaped",
+			data: map[string]interface{}{
+				"timestamp": "2024-05-01T12:00:00Z", "hostname": "host", "content": "hi",
+				"structured_data": map[string]interface{}{
+					"b@1": map[string]interface{}{"y": `a"b]c\d`, "x": 1},
+					"a@1": map[string]interface{}{},
+				},
+			},
+			want: `<13>1 2024-05-01T12:00:00.000000Z host - - - [a@1][b@1 x="1" y="a\"b\]c\\d"] hi`,
+		},
+		{
+			name: "invalid names left out",
+			data: map[string]interface{}{
+				"timestamp": "2024-05-01T12:00:00Z", "hostname": "host", "content": "hi",
+				"structured_data": map[string]interface{}{
+					"bad id":                            map[string]interface{}{"x": "1"},
+					"a=b":                               map[string]interface{}{"x": "1"},
+					"":                                  map[string]interface{}{"x": "1"},
+					"abcdefghijklmnopqrstuvwxyz0123456": map[string]interface{}{"x": "1"},
+					"ok@1": map[string]interface{}{
+						"good":     "1",
+						"bad name": "2",
+						`q"`:       "3",
+						"é":        "4",
+					},
+				},
+			},
+			want:    `<13>1 2024-05-01T12:00:00.000000Z host - - - [ok@1 good="1"] hi`,
+			invalid: 7,
+		},
+		{
+			name: "only invalid elements",
+			data: map[string]interface{}{
+				"timestamp": "2024-05-01T12:00:00Z", "hostname": "host", "content": "hi",
+				"structured_data": map[string]interface{}{"a]b": map[string]interface{}{}},
+			},
+			want:    "<13>1 2024-05-01T12:00:00.000000Z host - - - - hi",
+			invalid: 1,
+		},
+		{
+			name: "priority out of range",
+			data: map[string]interface{}{"priority": 192, "timestamp": "2024-05-01T12:00:00Z", "hostname": "host", "content": "hi"},
+			want: "<13>1 2024-05-01T12:00:00.000000Z host - - - - hi",
+		},
+		{
+			name: "negative priority",
+			data: map[string]interface{}{"priority": float64(-1), "timestamp": "2024-05-01T12:00:00Z", "hostname": "host", "content": "hi"},
+			want: "<13>1 2024-05-01T12:00:00.000000Z host - - - - hi",
+		},
+		{
+			name: "highest priority",
+			data: map[string]interface{}{"priority": float64(191), "timestamp": "2024-05-01T12:00:00Z", "hostname": "host", "content": "hi"},
+			want: "<191>1 2024-05-01T12:00:00.000000Z host - - - - hi",
+		},
+		{
+			name: "header fields sanitized",
+			data: map[string]interface{}{
+				"timestamp": "2024-05-01T12:00:00Z", "hostname": "my host", "app_name": "app\tname",
+				"proc_id": "é1", "msg_id": "", "content": "hi",
+			},
+			want: "<13>1 2024-05-01T12:00:00.000000Z my_host app_name _1 - - hi",
+		},
+		{
+			name: "header fields truncated",
+			data: map[string]interface{}{
+				"timestamp": "2024-05-01T12:00:00Z", "hostname": strings.Repeat("h", 300), "app_name": strings.Repeat("a", 60),
+				"proc_id": strings.Repeat("p", 130), "msg_id": strings.Repeat("m", 40), "content": "hi",
+			},
+			want: "<13>1 2024-05-01T12:00:00.000000Z " + strings.Repeat("h", 255) + " " + strings.Repeat("a", 48) + " " +
+				strings.Repeat("p", 128) + " " + strings.Repeat("m", 32) + " - hi",
+		},
+		{
+			name: "local hostname",
+			data: map[string]interface{}{"timestamp": "2024-05-01T12:00:00Z", "content": "hi"},
+			want: "<13>1 2024-05-01T12:00:00.000000Z local - - - - hi",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &syslogSink{hostname: "local", metric: fmt.Sprintf("sink.syslog%d", i)}
+			invalid := func() int64 {
+				if v, ok := Metrics.Get(s.metric + ".invalid_sd").(*expvar.Int); ok {
+					return v.Value()
+				}
+				return 0
+			}
+			before := invalid()
+
+			msg, err := s.formatRFC5424(NewEventFromMap(tt.data))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(msg))
+			assert.Equal(t, tt.invalid, invalid()-before)
+		})
+	}
+}
+
+func TestFormatRFC3164(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	stamp := ts.Local().Format(time.Stamp)
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		want string
+	}{
+		{
+			name: "full header",
+			data: map[string]interface{}{"priority": 165, "timestamp": "2024-05-01T12:00:00Z", "hostname": "host", "app_name": "app", "proc_id": "12", "content": "hi"},
+			want: "<165>" + stamp + " host app[12]: hi",
+		},
+		{
+			name: "without proc id",
+			data: map[string]interface{}{"timestamp": "2024-05-01T12:00:00Z", "hostname": "host", "app_name": "app", "content": "hi"},
+			want: "<13>" + stamp + " host app: hi",
+		},
+		{
+			name: "without app name",
+			data: map[string]interface{}{"timestamp": "2024-05-01T12:00:00Z", "hostname": "host", "proc_id": "12", "content": "hi"},
+			want: "<13>" + stamp + " host hi",
+		},
+		{
+			name: "priority out of range",
+			data: map[string]interface{}{"priority": 300, "timestamp": "2024-05-01T12:00:00Z", "hostname": "host", "content": "hi"},
+			want: "<13>" + stamp + " host hi",
+		},
+		{
+			name: "header fields sanitized",
+			data: map[string]interface{}{"timestamp": "2024-05-01T12:00:00Z", "hostname": "my host", "app_name": "my app", "content": "hi"},
+			want: "<13>" + stamp + " my_host my_app: hi",
+		},
+		{
+			name: "other event",
+			data: map[string]interface{}{"timestamp": "2024-05-01T12:00:00Z"},
+			want: "<13>" + stamp + ` local {"timestamp":"2024-05-01T12:00:00Z"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &syslogSink{hostname: "local", metric: "sink.syslog"}
+			msg, err := s.formatRFC3164(NewEventFromMap(tt.data))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(msg))
+		})
+	}
+}
+
+func TestSyslogSinkFraming(t *testing.T) {
+	const header = "<13>1 2024-05-01T12:00:00.000000Z host - - - - "
+	tests := []struct {
+		name    string
+		framing string
+		want    string
+	}{
+		{"newline escapes embedded newlines", FramingNewline, header + "a#012b\n"},
+		{"octet counting keeps newlines", FramingOctetCounting, "50 " + header + "a\nb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer ln.Close()
+			received := make(chan string, 1)
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				data, _ := io.ReadAll(bufio.NewReader(conn))
+				received <- string(data)
+			}()
+
+			sink, err := NewSink("test", &SinkConfig{Type: SinkSyslog, Address: ln.Addr().String(), Protocol: "tcp", Framing: tt.framing})
+			require.NoError(t, err)
+			require.NoError(t, sink.SendMessage(NewEventFromMap(map[string]interface{}{
+				"timestamp": "2024-05-01T12:00:00Z", "hostname": "host", "content": "a\nb",
+			})))
+			require.NoError(t, sink.Close())
+
+			select {
+			case got := <-received:
+				assert.Equal(t, tt.want, got)
+			case <-time.After(5 * time.Second):
+				t.Fatal("nothing received")
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	// Each event is an 8 byte line, so that two fit in a file
+	sink, err := NewSink("file", &SinkConfig{Type: SinkFile, Path: path, MaxSize: 16, MaxFiles: 2})
+	require.NoError(t, err)
+	for i := 0; i < 7; i++ {
+		require.NoError(t, sink.SendMessage(NewEventFromMap(map[string]interface{}{"n": i})))
+	}
+	require.NoError(t, sink.Close())
+
+	// The oldest file is dropped once max_files rotated files exist
+	for file, want := range map[string]string{
+		path:        "{\"n\":6}\n",
+		path + ".1": "{\"n\":4}\n{\"n\":5}\n",
+		path + ".2": "{\"n\":2}\n{\"n\":3}\n",
+	} {
+		got, err := os.ReadFile(file)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got), file)
+	}
+	assert.NoFileExists(t, path+".3")
+}
+
+func TestFileSinkAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	require.NoError(t, os.WriteFile(path, []byte("{\"n\":0}\n"), 0o644))
+
+	// The size of an existing file counts towards max_size
+	sink, err := NewSink("file", &SinkConfig{Type: SinkFile, Path: path, MaxSize: 16})
+	require.NoError(t, err)
+	for i := 1; i < 3; i++ {
+		require.NoError(t, sink.SendMessage(NewEventFromMap(map[string]interface{}{"n": i})))
+	}
+	require.NoError(t, sink.Close())
+
+	got, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "{\"n\":0}\n{\"n\":1}\n", string(got))
+	got, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"n\":2}\n", string(got))
+}
+
+func TestFileSinkErrors(t *testing.T) {
+	_, err := NewSink("file", &SinkConfig{Type: SinkFile})
+	assert.Error(t, err)
+	_, err = NewSink("file", &SinkConfig{Type: SinkFile, Path: filepath.Join(t.TempDir(), "missing", "events.log")})
+	assert.Error(t, err)
+}
+
+func TestStdoutSink(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	sink, err := NewSink("stdout", &SinkConfig{Type: SinkStdout})
+	os.Stdout = stdout
+	require.NoError(t, err)
+
+	buf := make([]byte, 8)
+	copy(buf, `{"a":1}`)
+	require.NoError(t, sink.SendMessage(NewEvent(buf[:7])))
+	require.NoError(t, sink.SendMessage(NewEventFromMap(map[string]interface{}{"b": "x"})))
+	require.NoError(t, sink.Close())
+	require.NoError(t, w.Close())
+
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"a\":1}\n{\"b\":\"x\"}\n", string(got))
+	// The newline is not written into the spare capacity of the event bytes
+	assert.Equal(t, byte(0), buf[7])
+}
@@ -2,54 +2,253 @@ package common
 
 import (
 	"fmt"
-	"github.com/bytedance/sonic"
+	"log/slog"
+	"net"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/vjeantet/grok"
 	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
 )
 
 // SyslogMessage represents a parsed syslog message
 type SyslogMessage struct {
-	Format    string                 `json:"format"`
-	Timestamp time.Time              `json:"timestamp"`
-	Hostname  string                 `json:"hostname"`
-	AppName   string                 `json:"app_name"`
-	ProcID    string                 `json:"proc_id"`
-	MsgID     string                 `json:"msg_id"`
-	Priority  int                    `json:"priority"`
-	Severity  int                    `json:"severity"`
-	Facility  int                    `json:"facility"`
-	Content   string                 `json:"content"`
-	Raw       map[string]interface{} `json:"raw,omitempty"`
+	Format         string                       `json:"format"`
+	Timestamp      time.Time                    `json:"timestamp"`
+	ReceivedAt     time.Time                    `json:"received_at"`
+	Hostname       string                       `json:"hostname"`
+	AppName        string                       `json:"app_name"`
+	ProcID         string                       `json:"proc_id"`
+	MsgID          string                       `json:"msg_id"`
+	Priority       int                          `json:"priority"`
+	Severity       int                          `json:"severity"`
+	SeverityName   string                       `json:"severity_name"`
+	Facility       int                          `json:"facility"`
+	FacilityName   string                       `json:"facility_name"`
+	StructuredData map[string]map[string]string `json:"structured_data,omitempty"`
+	Content        string                       `json:"content"`
+	Client         string                       `json:"client"`
+	TLSPeer        string                       `json:"tls_peer,omitempty"`
+	Raw            map[string]interface{}       `json:"raw,omitempty"`
+}
+
+var severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+var facilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// newSyslogMessage converts the fields produced by go-syslog into the
+// stable SyslogMessage schema. RFC3164 tags are reported as app_name and
+// RFC5424 nil values ("-") are reported as empty strings.
+func newSyslogMessage(format string, logParts map[string]interface{}, keepRaw bool) *SyslogMessage {
+	m := &SyslogMessage{
+		Format:   format,
+		Hostname: nilValue(logParts["hostname"]),
+		AppName:  nilValue(logParts["app_name"]),
+		ProcID:   nilValue(logParts["proc_id"]),
+		MsgID:    nilValue(logParts["msg_id"]),
+		Client:   nilValue(logParts["client"]),
+		TLSPeer:  nilValue(logParts["tls_peer"]),
+	}
+
+	// Messages without a timestamp are stamped with the reception time
+	m.ReceivedAt = time.Now()
+	if ts, ok := logParts["timestamp"].(time.Time); ok && !ts.IsZero() {
+		m.Timestamp = ts
+	} else {
+		m.Timestamp = m.ReceivedAt
+	}
+
+	m.Priority, _ = logParts["priority"].(int)
+	m.Severity, _ = logParts["severity"].(int)
+	m.Facility, _ = logParts["facility"].(int)
+	if m.Severity >= 0 && m.Severity < len(severityNames) {
+		m.SeverityName = severityNames[m.Severity]
+	}
+	if m.Facility >= 0 && m.Facility < len(facilityNames) {
+		m.FacilityName = facilityNames[m.Facility]
+	}
+
+	if tag, ok := logParts["tag"].(string); ok && m.AppName == "" {
+		m.AppName = tag
+	}
+	if content, ok := logParts["content"].(string); ok {
+		m.Content = content
+	} else if message, ok := logParts["message"].(string); ok {
+		m.Content = message
+	}
+	if sd, ok := logParts["structured_data"].(string); ok {
+		m.StructuredData = parseStructuredData(sd)
+	}
+
+	if keepRaw {
+		m.Raw = make(map[string]interface{}, len(logParts))
+		for k, v := range logParts {
+			m.Raw[k] = v
+		}
+	}
+
+	return m
+}
+
+// ToMap returns the message as the JSON object sent to Kafka, with the
+// timestamp formatted as RFC3339 with fractional seconds when present.
+func (m *SyslogMessage) ToMap() map[string]interface{} {
+	data := map[string]interface{}{
+		"format":        m.Format,
+		"timestamp":     m.Timestamp.Format(time.RFC3339Nano),
+		"received_at":   m.ReceivedAt.Format(time.RFC3339Nano),
+		"hostname":      m.Hostname,
+		"app_name":      m.AppName,
+		"proc_id":       m.ProcID,
+		"msg_id":        m.MsgID,
+		"priority":      m.Priority,
+		"severity":      m.Severity,
+		"severity_name": m.SeverityName,
+		"facility":      m.Facility,
+		"facility_name": m.FacilityName,
+		"content":       m.Content,
+		"client":        m.Client,
+	}
+	if len(m.StructuredData) > 0 {
+		sd := make(map[string]interface{}, len(m.StructuredData))
+		for id, params := range m.StructuredData {
+			elem := make(map[string]interface{}, len(params))
+			for k, v := range params {
+				elem[k] = v
+			}
+			sd[id] = elem
+		}
+		data["structured_data"] = sd
+	}
+	if m.TLSPeer != "" {
+		data["tls_peer"] = m.TLSPeer
+	}
+	if m.Raw != nil {
+		data["raw"] = m.Raw
+	}
+	return data
+}
+
+// clientIP strips the port from a host:port peer address.
+func clientIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func nilValue(v interface{}) string {
+	str, _ := v.(string)
+	if str == "-" {
+		return ""
+	}
+	return str
+}
+
+// parseStructuredData parses RFC5424 structured data such as
+// `[id@1 a="1" b="2"][id@2 c="3"]` into a map of SD-ID to parameters.
+// Only the escapes defined by RFC5424 (\", \\ and \]) are decoded, other
+// backslashes are kept. Malformed trailing elements are ignored.
+func parseStructuredData(sd string) map[string]map[string]string {
+	if sd == "" || sd == "-" {
+		return nil
+	}
+
+	res := make(map[string]map[string]string)
+	i := 0
+	for i < len(sd) && sd[i] == '[' {
+		i++
+		start := i
+		for i < len(sd) && sd[i] != ' ' && sd[i] != ']' {
+			i++
+		}
+		if i >= len(sd) {
+			break
+		}
+		params := make(map[string]string)
+		res[sd[start:i]] = params
+
+		for i < len(sd) && sd[i] == ' ' {
+			i++
+			nameStart := i
+			for i < len(sd) && sd[i] != '=' {
+				i++
+			}
+			if i+1 >= len(sd) || sd[i+1] != '"' {
+				return res
+			}
+			name := sd[nameStart:i]
+			i += 2
+
+			var sb strings.Builder
+			for i < len(sd) && sd[i] != '"' {
+				if sd[i] == '\\' && i+1 < len(sd) && (sd[i+1] == '"' || sd[i+1] == '\\' || sd[i+1] == ']') {
+					i++
+				}
+				sb.WriteByte(sd[i])
+				i++
+			}
+			if i >= len(sd) {
+				return res
+			}
+			params[name] = sb.String()
+			i++
+		}
+
+		if i >= len(sd) || sd[i] != ']' {
+			return res
+		}
+		i++
+	}
+	return res
 }
 
 type SyslogConfig struct {
 	listen   string
 	protocol string
 	format   string
-	msgChan  chan []byte
+	msgChan  chan *Event
 
-	innerChannel syslog.LogPartsChannel
-	msgHandler   *syslog.ChannelHandler
-	server       *syslog.Server
+	innerChannel chan format.LogParts
+	server       *syslogServer
+	log          *slog.Logger
+
+	// done stops the goroutine started by Run, which closes stopped once
+	// pending multiline events are sent
+	done    chan struct{}
+	stopped chan struct{}
+	started atomic.Bool
+
+	keepRaw   bool
+	parsers   *ContentParsers
+	pipeline  *Pipeline
+	limiter   *RateLimiter
+	multiline *MultilineConfig
 
 	grok        *grok.Grok
 	grokPattern string
 }
 
-func NewSyslog(listen, protocol string, format string, msgChan chan []byte) (*SyslogConfig, error) {
+func NewSyslog(listen, protocol string, msgFormat string, msgChan chan *Event) (*SyslogConfig, error) {
 	var err error
 
 	s := &SyslogConfig{
 		listen:   listen,
 		protocol: protocol,
-		format:   format,
+		format:   msgFormat,
 		msgChan:  msgChan,
+		log:      ComponentLogger("syslog", "listen", listen),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
 	}
 
-	s.innerChannel = make(syslog.LogPartsChannel)
-	s.msgHandler = syslog.NewChannelHandler(s.innerChannel)
+	s.innerChannel = make(chan format.LogParts)
 
 	//s.grok, _ = grok.NewWithConfig(&grok.Config{
 	//	NamedCapturesOnly:   named_captures_only,
@@ -58,66 +257,169 @@ func NewSyslog(listen, protocol string, format string, msgChan chan []byte) (*Sy
 	//})
 	//s.grokPattern = grokPattern
 
-	s.server = syslog.NewServer()
-
 	// Set format based on config
+	var f format.Format
 	switch s.format {
 	case "":
-		s.server.SetFormat(syslog.RFC5424)
+		f = syslog.RFC5424
 	case "RFC3164":
-		s.server.SetFormat(syslog.RFC3164)
+		f = syslog.RFC3164
 	case "RFC5424":
-		s.server.SetFormat(syslog.RFC5424)
+		f = syslog.RFC5424
 	case "RFC6587":
-		s.server.SetFormat(syslog.RFC6587)
+		f = syslog.RFC6587
+	case "auto":
+		f = &autoFormat{}
 	default:
 		return nil, fmt.Errorf("unsupported syslog format: %s", s.format)
 	}
 
-	s.server.SetHandler(s.msgHandler)
-
 	switch s.protocol {
-	case "tcp":
-		err = s.server.ListenTCP(s.listen)
-	case "udp":
-		err = s.server.ListenUDP(s.listen)
-	case "unixgram":
-		err = s.server.ListenUnixgram(s.listen)
+	case "tcp", "tls", "udp", "unixgram":
 	default:
 		return nil, fmt.Errorf("unsupported syslog protocol: %s", s.protocol)
 	}
 
+	s.server, err = newSyslogServer(s.protocol, s.listen, f, s.innerChannel)
 	if err != nil {
 		return nil, fmt.Errorf("server listen error: %s", err.Error())
 	}
 
-	err = s.server.Boot()
+	return s, nil
+}
+
+// SetACL sets the IP access lists checked when a TCP or TLS connection is
+// accepted or a datagram is received. Unixgram peers are not checked.
+func (s *SyslogConfig) SetACL(acl *ACL) {
+	s.server.SetACL(acl)
+}
+
+// SetTLS sets the certificate of a tls listener and, optionally, the CAs
+// client certificates are verified against. It is required for the tls
+// protocol.
+func (s *SyslogConfig) SetTLS(cfg *ServerTLSConfig) error {
+	if s.protocol != "tls" {
+		return fmt.Errorf("tls settings require the tls protocol")
+	}
+	conf, err := newServerTLS(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("server boot error: %s", err.Error())
+		return err
 	}
+	return s.server.SetTLS(conf)
+}
 
-	return s, nil
+// SetKeepRaw controls whether the original go-syslog fields are retained
+// under the "raw" key of each emitted message.
+func (s *SyslogConfig) SetKeepRaw(keepRaw bool) {
+	s.keepRaw = keepRaw
+}
+
+// SetContentParsers sets the parsers applied to the content of every
+// message.
+func (s *SyslogConfig) SetContentParsers(parsers *ContentParsers) {
+	s.parsers = parsers
+}
+
+// SetPipeline sets the processors applied to every message before it is
+// queued for Kafka.
+func (s *SyslogConfig) SetPipeline(pipeline *Pipeline) {
+	s.pipeline = pipeline
+}
+
+// SetRateLimiter sets the limiter applied per listener and sender address.
+// The reject action drops messages as syslog has no way to push back.
+func (s *SyslogConfig) SetRateLimiter(limiter *RateLimiter) {
+	s.limiter = limiter
+}
+
+// SetMultiline sets the rules joining consecutive messages of one sender
+// into one event. It returns an error when the patterns are invalid.
+func (s *SyslogConfig) SetMultiline(cfg *MultilineConfig) error {
+	if _, err := newMultilineAggregator(cfg, nil); err != nil {
+		return err
+	}
+	s.multiline = cfg
+	return nil
 }
 
 func (s *SyslogConfig) Run() {
-	go func(channel syslog.LogPartsChannel) {
-		var data = make(map[string]interface{})
-		for logParts := range channel {
-			for k, v := range logParts {
-				data[k] = v
-			}
-			dataBytes, err := sonic.Marshal(data)
-			if err != nil {
-				fmt.Println("syslog marshal json err: ", err.Error())
-				continue
+	defaultFormat := s.format
+	if defaultFormat == "" {
+		defaultFormat = "RFC5424"
+	}
+
+	var aggregator *multilineAggregator
+	var flush <-chan time.Time
+	if s.multiline != nil {
+		aggregator, _ = newMultilineAggregator(s.multiline, s.send)
+	}
+
+	s.started.Store(true)
+	go func(channel chan format.LogParts) {
+		defer close(s.stopped)
+		if aggregator != nil {
+			ticker := time.NewTicker(aggregator.FlushInterval())
+			defer ticker.Stop()
+			flush = ticker.C
+		}
+		for {
+			select {
+			case logParts := <-channel:
+				msgFormat := defaultFormat
+				if detected, ok := logParts["format"].(string); ok {
+					msgFormat = detected
+					delete(logParts, "format")
+				}
+				msg := newSyslogMessage(msgFormat, logParts, s.keepRaw)
+				if aggregator != nil {
+					aggregator.Add(msg, time.Now())
+				} else {
+					s.send(msg)
+				}
+			case now := <-flush:
+				aggregator.Flush(now)
+			case <-s.done:
+				if aggregator != nil {
+					aggregator.FlushAll()
+				}
+				return
 			}
-			s.msgChan <- dataBytes
 		}
 	}(s.innerChannel)
+
+	s.server.Serve()
+}
+
+// send applies rate limits and content parsers to msg and queues it.
+func (s *SyslogConfig) send(msg *SyslogMessage) {
+	limited := !s.limiter.Allow(clientIP(msg.Client), "")
+	if limited && s.limiter.Action() != RateLimitTag {
+		return
+	}
+
+	data := msg.ToMap()
+	if limited {
+		s.limiter.Tag(data)
+	}
+	if s.parsers != nil {
+		s.parsers.Apply(data, msg.Content)
+	}
+	sendEvent(s.pipeline, NewEventFromMap(data), s.msgChan, s.log)
 }
 
+// Stop closes the listener and waits until pending multiline events are
+// sent.
 func (s *SyslogConfig) Stop() {
-	_ = s.server.Kill()
+	_ = s.server.Close()
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	close(s.done)
+	if s.started.Load() {
+		<-s.stopped
+	}
 }
 
 func (s *SyslogConfig) ListenAddr() string {
@@ -0,0 +1,93 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+const (
+	framingUnknown = iota
+	framingOctetCounting
+	framingNewline
+)
+
+// autoFormat detects RFC3164 or RFC5424 for every message. On stream
+// connections the framing (RFC6587 octet counting or newline delimited) is
+// detected from the first frame and kept for the rest of the connection.
+type autoFormat struct{}
+
+// detectedParser records the format chosen by autoFormat in the parsed fields.
+type detectedParser struct {
+	format.LogParser
+	format string
+}
+
+func (p *detectedParser) Dump() format.LogParts {
+	logParts := p.LogParser.Dump()
+	logParts["format"] = p.format
+	return logParts
+}
+
+func (f *autoFormat) GetParser(line []byte) format.LogParser {
+	if isRFC5424(line) {
+		return &detectedParser{syslog.RFC5424.GetParser(line), "RFC5424"}
+	}
+	return &detectedParser{syslog.RFC3164.GetParser(line), "RFC3164"}
+}
+
+// GetSplitFunc is called once per stream connection (and once per datagram),
+// so the framing state lives in the returned closure.
+func (f *autoFormat) GetSplitFunc() bufio.SplitFunc {
+	framing := framingUnknown
+	octetSplit := syslog.RFC6587.GetSplitFunc()
+
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+
+		if framing == framingUnknown {
+			i := bytes.IndexAny(data, " \n")
+			switch {
+			case i < 0 && !atEOF:
+				// Request more data
+				return 0, nil, nil
+			case i > 0 && data[i] == ' ' && isDigits(data[:i]):
+				framing = framingOctetCounting
+			default:
+				framing = framingNewline
+			}
+		}
+
+		if framing == framingOctetCounting {
+			return octetSplit(data, atEOF)
+		}
+		return bufio.ScanLines(data, atEOF)
+	}
+}
+
+// isRFC5424 reports whether line starts with a PRI followed by a version
+// number, as RFC3164 headers continue with a month name instead.
+func isRFC5424(line []byte) bool {
+	if len(line) == 0 || line[0] != '<' {
+		return false
+	}
+	angle := bytes.IndexByte(line, '>')
+	if angle < 2 || angle > 4 {
+		return false
+	}
+	space := bytes.IndexByte(line[angle+1:], ' ')
+	return space > 0 && isDigits(line[angle+1:angle+1+space])
+}
+
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(b) > 0
+}
@@ -0,0 +1,89 @@
+package common
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoFormatFraming(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   []string
+	}{
+		{
+			name:   "octet counting",
+			stream: "11 <13>1 - - a5 <13>b",
+			want:   []string{"<13>1 - - a", "<13>b"},
+		},
+		{
+			name:   "octet counting with newline in message",
+			stream: "9 <13>a\nb c3 <1>",
+			want:   []string{"<13>a\nb c", "<1>"},
+		},
+		{
+			name:   "newline delimited",
+			stream: "<13>Oct 11 22:14:15 host app: one\n<13>Oct 11 22:14:16 host app: two\n",
+			want:   []string{"<13>Oct 11 22:14:15 host app: one", "<13>Oct 11 22:14:16 host app: two"},
+		},
+		{
+			name:   "newline delimited frames starting with digits",
+			stream: "<13>first\n12 not a length\n",
+			want:   []string{"<13>first", "12 not a length"},
+		},
+		{
+			name:   "single datagram without newline",
+			stream: "<13>1 2024-05-01T12:00:00Z host app - - - hello",
+			want:   []string{"<13>1 2024-05-01T12:00:00Z host app - - - hello"},
+		},
+		{
+			name:   "empty stream",
+			stream: "",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanner := bufio.NewScanner(strings.NewReader(tt.stream))
+			// A tiny buffer makes the split function see partial frames
+			scanner.Buffer(make([]byte, 4), 1024)
+			scanner.Split((&autoFormat{}).GetSplitFunc())
+			var got []string
+			for scanner.Scan() {
+				got = append(got, scanner.Text())
+			}
+			require.NoError(t, scanner.Err())
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAutoFormatParser(t *testing.T) {
+	tests := []struct {
+		line     string
+		format   string
+		hostname string
+	}{
+		{`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 - event`, "RFC5424", "mymachine.example.com"},
+		{`<34>Oct 11 22:14:15 mymachine su: 'su root' failed`, "RFC3164", "mymachine"},
+		{`<34>1Oct 11 22:14:15 mymachine su: no space after version`, "RFC3164", ""},
+		{`no priority at all`, "RFC3164", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			parser := (&autoFormat{}).GetParser([]byte(tt.line))
+			_ = parser.Parse()
+			logParts := parser.Dump()
+			assert.Equal(t, tt.format, logParts["format"])
+			if tt.hostname != "" {
+				assert.Equal(t, tt.hostname, logParts["hostname"])
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package common
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+const (
+	datagramReadBufferSize = 64 * 1024
+	// datagramQueueSize bounds the datagrams read but not parsed yet
+	datagramQueueSize = 1024
+	// tlsHandshakeTimeout bounds the handshake of a TLS connection
+	tlsHandshakeTimeout = 10 * time.Second
+	// acceptMinBackoff and acceptMaxBackoff bound the delay after a failed
+	// Accept or datagram read, such as when the process runs out of file
+	// descriptors
+	acceptMinBackoff = 5 * time.Millisecond
+	acceptMaxBackoff = time.Second
+)
+
+// ServerTLSConfig represents the TLS settings of a syslog listener. With
+// ClientCAFile set, clients must present a certificate signed by one of its
+// CAs, and the certificate common name is reported as tls_peer.
+type ServerTLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file,omitempty"`
+}
+
+// newServerTLS builds the tls.Config described by cfg.
+func newServerTLS(cfg *ServerTLSConfig) (*tls.Config, error) {
+	if cfg == nil || cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("cert_file and key_file are required for tls")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		conf.ClientCAs = x509.NewCertPool()
+		if !conf.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.ClientCAFile)
+		}
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// syslogServer receives syslog messages over TCP, TLS, UDP or unixgram and
+// sends the parsed fields to a channel. It follows go-syslog's Server but
+// checks the ACL when a connection is accepted and for every datagram,
+// before anything is parsed.
+type syslogServer struct {
+	format   format.Format
+	handler  chan<- format.LogParts
+	acl      *ACL
+	listener net.Listener
+	conn     net.PacketConn
+
+	// done is closed by Close to release goroutines blocked on handler
+	done chan struct{}
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+func newSyslogServer(protocol, addr string, f format.Format, handler chan<- format.LogParts) (*syslogServer, error) {
+	s := &syslogServer{
+		format:  f,
+		handler: handler,
+		done:    make(chan struct{}),
+		conns:   make(map[net.Conn]struct{}),
+	}
+
+	var err error
+	switch protocol {
+	case "tcp", "tls":
+		s.listener, err = net.Listen("tcp", addr)
+	case "udp", "unixgram":
+		s.conn, err = net.ListenPacket(protocol, addr)
+		if err == nil {
+			if rb, ok := s.conn.(interface{ SetReadBuffer(int) error }); ok {
+				_ = rb.SetReadBuffer(datagramReadBufferSize)
+			}
+		}
+	default:
+		return nil, errors.New("unsupported protocol: " + protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetACL sets the access lists checked for every peer. It must be called
+// before Serve.
+func (s *syslogServer) SetACL(acl *ACL) {
+	s.acl = acl
+}
+
+// SetTLS makes the stream listener accept TLS connections only. It must be
+// called before Serve.
+func (s *syslogServer) SetTLS(conf *tls.Config) error {
+	if s.listener == nil {
+		return fmt.Errorf("tls requires a stream listener")
+	}
+	s.listener = tls.NewListener(s.listener, conf)
+	return nil
+}
+
+// Addr returns the address the server listens on.
+func (s *syslogServer) Addr() net.Addr {
+	if s.listener != nil {
+		return s.listener.Addr()
+	}
+	return s.conn.LocalAddr()
+}
+
+// Serve starts the goroutines accepting connections or reading and parsing
+// datagrams.
+func (s *syslogServer) Serve() {
+	if s.listener != nil {
+		go s.acceptConnections()
+		return
+	}
+
+	datagrams := make(chan datagram, datagramQueueSize)
+	go s.receiveDatagrams(datagrams)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go func() {
+			for d := range datagrams {
+				s.parse(d.line, d.client, "")
+			}
+		}()
+	}
+}
+
+func (s *syslogServer) acceptConnections() {
+	var backoff time.Duration
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Back off like net/http so errors such as EMFILE do not spin
+			if backoff == 0 {
+				backoff = acceptMinBackoff
+			} else if backoff *= 2; backoff > acceptMaxBackoff {
+				backoff = acceptMaxBackoff
+			}
+			select {
+			case <-time.After(backoff):
+			case <-s.done:
+				return
+			}
+			continue
+		}
+		backoff = 0
+		if !s.acl.Allowed(conn.RemoteAddr().String()) {
+			conn.Close()
+			continue
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		go s.scanConnection(conn)
+	}
+}
+
+func (s *syslogServer) scanConnection(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	client := conn.RemoteAddr().String()
+	var tlsPeer string
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		_ = tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		_ = tlsConn.SetDeadline(time.Time{})
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			tlsPeer = certs[0].Subject.CommonName
+		}
+	}
+
+	scanner := bufio.NewScanner(conn)
+	if sf := s.format.GetSplitFunc(); sf != nil {
+		scanner.Split(sf)
+	}
+	for scanner.Scan() {
+		if !s.parse([]byte(scanner.Text()), client, tlsPeer) {
+			return
+		}
+	}
+}
+
+// datagram is a received datagram waiting to be parsed
+type datagram struct {
+	line   []byte
+	client string
+}
+
+// receiveDatagrams reads datagrams and queues them for the parsing
+// goroutines, so that parsing does not hold up reading. It closes
+// datagrams when the connection is closed.
+func (s *syslogServer) receiveDatagrams(datagrams chan<- datagram) {
+	defer close(datagrams)
+
+	buf := make([]byte, datagramReadBufferSize)
+	_, isUnix := s.conn.(*net.UnixConn)
+	var backoff time.Duration
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// A persistent socket error must not spin
+			if backoff == 0 {
+				backoff = acceptMinBackoff
+			} else if backoff *= 2; backoff > acceptMaxBackoff {
+				backoff = acceptMaxBackoff
+			}
+			select {
+			case <-time.After(backoff):
+			case <-s.done:
+				return
+			}
+			continue
+		}
+		backoff = 0
+
+		var client string
+		if addr != nil {
+			client = addr.String()
+		}
+		if !isUnix && !s.acl.Allowed(client) {
+			continue
+		}
+
+		// Ignore trailing control characters and NULs
+		for ; n > 0 && buf[n-1] < 32; n-- {
+		}
+		if n == 0 {
+			continue
+		}
+
+		line := make([]byte, n)
+		copy(line, buf[:n])
+		if sf := s.format.GetSplitFunc(); sf != nil {
+			if _, token, err := sf(line, true); err == nil && token != nil {
+				line = token
+			}
+		}
+		select {
+		case datagrams <- datagram{line: line, client: client}:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// parse hands the fields of line to the handler. Like go-syslog, fields are
+// forwarded even when the line could only be parsed partially. It returns
+// false when the server was closed before the handler took the fields.
+func (s *syslogServer) parse(line []byte, client, tlsPeer string) bool {
+	parser := s.format.GetParser(line)
+	_ = parser.Parse()
+
+	logParts := parser.Dump()
+	logParts["client"] = client
+	logParts["tls_peer"] = tlsPeer
+	select {
+	case s.handler <- logParts:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// Close stops accepting messages, closes open connections and releases
+// goroutines waiting on the handler.
+func (s *syslogServer) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return s.conn.Close()
+}
@@ -0,0 +1,275 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// testCerts holds the files of a CA and of a server and client certificate
+// it signed.
+type testCerts struct {
+	CA, ServerCert, ServerKey, ClientCert, ClientKey string
+}
+
+func writeTestCerts(t *testing.T) testCerts {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+		return path
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	issue := func(serial int64, cn string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			DNSNames:     []string{"localhost"},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+		return der, keyDER
+	}
+
+	serverDER, serverKey := issue(2, "localhost", x509.ExtKeyUsageServerAuth)
+	clientDER, clientKey := issue(3, "relay-1", x509.ExtKeyUsageClientAuth)
+	return testCerts{
+		CA:         write("ca.pem", "CERTIFICATE", caDER),
+		ServerCert: write("server.pem", "CERTIFICATE", serverDER),
+		ServerKey:  write("server.key", "EC PRIVATE KEY", serverKey),
+		ClientCert: write("client.pem", "CERTIFICATE", clientDER),
+		ClientKey:  write("client.key", "EC PRIVATE KEY", clientKey),
+	}
+}
+
+func startTestSyslogServer(t *testing.T, protocol string, f format.Format, setup func(*syslogServer)) (*syslogServer, chan format.LogParts) {
+	t.Helper()
+	handler := make(chan format.LogParts, 16)
+	addr := "127.0.0.1:0"
+	s, err := newSyslogServer(protocol, addr, f, handler)
+	require.NoError(t, err)
+	if setup != nil {
+		setup(s)
+	}
+	s.Serve()
+	t.Cleanup(func() { s.Close() })
+	return s, handler
+}
+
+func receiveLogParts(t *testing.T, handler chan format.LogParts, n int) []format.LogParts {
+	t.Helper()
+	var res []format.LogParts
+	for len(res) < n {
+		select {
+		case logParts := <-handler:
+			res = append(res, logParts)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d messages, want %d", len(res), n)
+		}
+	}
+	return res
+}
+
+func TestSyslogServerStreamFraming(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  format.Format
+		payload string
+		want    []string
+	}{
+		{
+			name:    "newline",
+			format:  syslog.RFC3164,
+			payload: "<13>Oct 11 22:14:15 host app: first\n<13>Oct 11 22:14:16 host app: second\n",
+			want:    []string{"first", "second"},
+		},
+		{
+			name:    "octet counting",
+			format:  syslog.RFC6587,
+			payload: "45 <13>1 2024-05-01T12:00:00Z host app - - - one46 <13>1 2024-05-01T12:00:00Z host app - - - two\n",
+			want:    []string{"one", "two\n"},
+		},
+		{
+			name:    "auto octet counting",
+			format:  &autoFormat{},
+			payload: "45 <13>1 2024-05-01T12:00:00Z host app - - - one45 <13>1 2024-05-01T12:00:00Z host app - - - two",
+			want:    []string{"one", "two"},
+		},
+		{
+			name:    "auto newline",
+			format:  &autoFormat{},
+			payload: "<13>1 2024-05-01T12:00:00Z host app - - - one\n<13>Oct 11 22:14:16 host app: two\n",
+			want:    []string{"one", "two"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, handler := startTestSyslogServer(t, "tcp", tt.format, nil)
+			conn, err := net.Dial("tcp", s.Addr().String())
+			require.NoError(t, err)
+			_, err = conn.Write([]byte(tt.payload))
+			require.NoError(t, err)
+			conn.Close()
+
+			for i, logParts := range receiveLogParts(t, handler, len(tt.want)) {
+				content, _ := logParts["content"].(string)
+				if content == "" {
+					content, _ = logParts["message"].(string)
+				}
+				assert.Equal(t, tt.want[i], content)
+				assert.Equal(t, conn.LocalAddr().String(), logParts["client"])
+				assert.Equal(t, "", logParts["tls_peer"])
+			}
+		})
+	}
+}
+
+func TestSyslogServerUDP(t *testing.T) {
+	s, handler := startTestSyslogServer(t, "udp", &autoFormat{}, nil)
+	conn, err := net.Dial("udp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for _, msg := range []string{
+		"<13>1 2024-05-01T12:00:00Z host app - - - one\n",
+		"<13>Oct 11 22:14:16 host app: two\x00",
+		"35 <13>Oct 11 22:14:16 host app: three",
+	} {
+		_, err = conn.Write([]byte(msg))
+		require.NoError(t, err)
+	}
+
+	got := make(map[string]string)
+	for _, logParts := range receiveLogParts(t, handler, 3) {
+		assert.Equal(t, conn.LocalAddr().String(), logParts["client"])
+		content, _ := logParts["content"].(string)
+		if content == "" {
+			content, _ = logParts["message"].(string)
+		}
+		got[content], _ = logParts["format"].(string)
+	}
+	assert.Equal(t, map[string]string{"one": "RFC5424", "two": "RFC3164", "three": "RFC3164"}, got)
+}
+
+func TestSyslogServerTLS(t *testing.T) {
+	certs := writeTestCerts(t)
+	serverConf, err := newServerTLS(&ServerTLSConfig{CertFile: certs.ServerCert, KeyFile: certs.ServerKey, ClientCAFile: certs.CA})
+	require.NoError(t, err)
+	s, handler := startTestSyslogServer(t, "tls", syslog.RFC6587, func(s *syslogServer) {
+		require.NoError(t, s.SetTLS(serverConf))
+	})
+
+	clientConf, err := newClientTLS(&ClientTLSConfig{CAFile: certs.CA, CertFile: certs.ClientCert, KeyFile: certs.ClientKey}, s.Addr().String())
+	require.NoError(t, err)
+	conn, err := tls.Dial("tcp", s.Addr().String(), clientConf)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("45 <13>1 2024-05-01T12:00:00Z host app - - - one45 <13>1 2024-05-01T12:00:00Z host app - - - two"))
+	require.NoError(t, err)
+	conn.Close()
+
+	for i, logParts := range receiveLogParts(t, handler, 2) {
+		assert.Equal(t, []string{"one", "two"}[i], logParts["message"])
+		assert.Equal(t, "relay-1", logParts["tls_peer"])
+	}
+
+	// Clients without a certificate fail the handshake
+	clientConf, err = newClientTLS(&ClientTLSConfig{CAFile: certs.CA}, s.Addr().String())
+	require.NoError(t, err)
+	conn, err = tls.Dial("tcp", s.Addr().String(), clientConf)
+	if err == nil {
+		_, _ = conn.Write([]byte("45 <13>1 2024-05-01T12:00:00Z host app - - - bad"))
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	assert.Error(t, err)
+	select {
+	case logParts := <-handler:
+		t.Fatalf("unexpected message %v", logParts)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSyslogServerACL(t *testing.T) {
+	acl, err := NewACL("127.0.0.1:0", &ACLConfig{Deny: []string{"127.0.0.0/8"}})
+	require.NoError(t, err)
+	s, handler := startTestSyslogServer(t, "tcp", syslog.RFC3164, func(s *syslogServer) { s.SetACL(acl) })
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	_, _ = conn.Write([]byte("<13>Oct 11 22:14:15 host app: denied\n"))
+	conn.Close()
+
+	select {
+	case logParts := <-handler:
+		t.Fatalf("unexpected message %v", logParts)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSyslogServerCloseReleasesHandler(t *testing.T) {
+	// Nobody reads the unbuffered handler, as after the consumer stopped
+	handler := make(chan format.LogParts)
+	s, err := newSyslogServer("tcp", "127.0.0.1:0", syslog.RFC3164, handler)
+	require.NoError(t, err)
+	s.Serve()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("<13>Oct 11 22:14:15 host app: blocked\n"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, s.Close())
+	require.NoError(t, s.Close())
+	done := make(chan bool)
+	go func() { done <- s.parse([]byte("<13>Oct 11 22:14:15 host app: late"), "", "") }()
+	select {
+	case ok := <-done:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("parse blocked after Close")
+	}
+}
@@ -0,0 +1,164 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+func parseSyslogLine(t *testing.T, f format.Format, line string) format.LogParts {
+	t.Helper()
+	parser := f.GetParser([]byte(line))
+	require.NoError(t, parser.Parse())
+	logParts := parser.Dump()
+	logParts["client"] = "192.0.2.10:51514"
+	return logParts
+}
+
+func TestSyslogMessageFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		parser format.Format
+		line   string
+		want   map[string]interface{}
+	}{
+		{
+			name:   "RFC3164",
+			format: "RFC3164",
+			parser: syslog.RFC3164,
+			line:   "<34>Oct 11 22:14:15 mymachine su[42]: 'su root' failed for lonvick on /dev/pts/8",
+			want: map[string]interface{}{
+				"format":        "RFC3164",
+				"hostname":      "mymachine",
+				"app_name":      "su",
+				"proc_id":       "",
+				"msg_id":        "",
+				"priority":      34,
+				"severity":      2,
+				"severity_name": "crit",
+				"facility":      4,
+				"facility_name": "auth",
+				"content":       "'su root' failed for lonvick on /dev/pts/8",
+				"client":        "192.0.2.10:51514",
+			},
+		},
+		{
+			name:   "RFC5424",
+			format: "RFC5424",
+			parser: syslog.RFC5424,
+			line:   `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application"] An application event`,
+			want: map[string]interface{}{
+				"format":        "RFC5424",
+				"timestamp":     "2003-10-11T22:14:15.003Z",
+				"hostname":      "mymachine.example.com",
+				"app_name":      "evntslog",
+				"proc_id":       "",
+				"msg_id":        "ID47",
+				"priority":      165,
+				"severity":      5,
+				"severity_name": "notice",
+				"facility":      20,
+				"facility_name": "local4",
+				"content":       "An application event",
+				"client":        "192.0.2.10:51514",
+				"structured_data": map[string]interface{}{
+					"exampleSDID@32473": map[string]interface{}{"iut": "3", "eventSource": "Application"},
+				},
+			},
+		},
+		{
+			name:   "RFC5424 without structured data",
+			format: "RFC5424",
+			parser: syslog.RFC5424,
+			line:   `<13>1 2024-05-01T12:00:00+02:00 host app 123 - - plain message`,
+			want: map[string]interface{}{
+				"format":        "RFC5424",
+				"timestamp":     "2024-05-01T12:00:00+02:00",
+				"hostname":      "host",
+				"app_name":      "app",
+				"proc_id":       "123",
+				"msg_id":        "",
+				"priority":      13,
+				"severity":      5,
+				"severity_name": "notice",
+				"facility":      1,
+				"facility_name": "user",
+				"content":       "plain message",
+				"client":        "192.0.2.10:51514",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := newSyslogMessage(tt.format, parseSyslogLine(t, tt.parser, tt.line), false).ToMap()
+			_, err := time.Parse(time.RFC3339Nano, data["received_at"].(string))
+			assert.NoError(t, err)
+			delete(data, "received_at")
+			if _, ok := tt.want["timestamp"]; !ok {
+				// RFC3164 timestamps carry no year or zone
+				_, err := time.Parse(time.RFC3339Nano, data["timestamp"].(string))
+				assert.NoError(t, err)
+				delete(data, "timestamp")
+			}
+			assert.Equal(t, tt.want, data)
+		})
+	}
+}
+
+func TestSyslogMessageKeepRaw(t *testing.T) {
+	logParts := parseSyslogLine(t, syslog.RFC5424, `<13>1 2024-05-01T12:00:00Z host app - - [a@1 x="1"] first`)
+	data := newSyslogMessage("RFC5424", logParts, true).ToMap()
+	raw, ok := data["raw"].(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, `[a@1 x="1"]`, raw["structured_data"])
+
+	// A following message without structured data must not inherit it
+	logParts = parseSyslogLine(t, syslog.RFC5424, `<13>1 2024-05-01T12:00:01Z host app - - - second`)
+	data = newSyslogMessage("RFC5424", logParts, false).ToMap()
+	assert.NotContains(t, data, "structured_data")
+	assert.NotContains(t, data, "raw")
+}
+
+func TestParseStructuredData(t *testing.T) {
+	tests := []struct {
+		name string
+		sd   string
+		want map[string]map[string]string
+	}{
+		{"nil value", "-", nil},
+		{"empty", "", nil},
+		{"element without params", "[id@1]", map[string]map[string]string{"id@1": {}}},
+		{
+			"several elements",
+			`[id@1 a="1" b="2"][id@2 c="3"]`,
+			map[string]map[string]string{"id@1": {"a": "1", "b": "2"}, "id@2": {"c": "3"}},
+		},
+		{
+			"defined escapes",
+			`[id@1 q="say \"hi\"" b="a\\b" r="x\]y"]`,
+			map[string]map[string]string{"id@1": {"q": `say "hi"`, "b": `a\b`, "r": "x]y"}},
+		},
+		{
+			"other backslashes kept",
+			`[id@1 path="C:\temp\new" re="\d+"]`,
+			map[string]map[string]string{"id@1": {"path": `C:\temp\new`, "re": `\d+`}},
+		},
+		{
+			"malformed trailing element",
+			`[id@1 a="1"][id@2 b=`,
+			map[string]map[string]string{"id@1": {"a": "1"}, "id@2": {}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseStructuredData(tt.sd))
+		})
+	}
+}
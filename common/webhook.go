@@ -1,23 +1,36 @@
 package common
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strings"
-
-	"github.com/bytedance/sonic"
+	"time"
 )
 
+// webhookStopTimeout bounds the wait for running requests on Stop
+const webhookStopTimeout = 5 * time.Second
+
 type WebhookServer struct {
 	listen  string
 	path    string
-	msgChan chan []byte
+	msgChan chan *Event
 	server  *http.Server
 	tls     *WebhookTLSConfig
+
+	pipeline *Pipeline
+	limiter  *RateLimiter
+	acl      *ACL
+	log      *slog.Logger
+
+	remoteAddrField []string
+	schema          *JSONSchema
+	quarantineTopic string
 }
 
-func NewWebhook(listen, path string, msgChan chan []byte, tlsConfig *WebhookTLSConfig) (*WebhookServer, error) {
+func NewWebhook(listen, path string, msgChan chan *Event, tlsConfig *WebhookTLSConfig) (*WebhookServer, error) {
 	if !strings.HasPrefix(listen, "http://") && !strings.HasPrefix(listen, "https://") {
 		return nil, fmt.Errorf("listen address must start with http:// or https://")
 	}
@@ -31,6 +44,7 @@ func NewWebhook(listen, path string, msgChan chan []byte, tlsConfig *WebhookTLSC
 		path:    path,
 		msgChan: msgChan,
 		tls:     tlsConfig,
+		log:     ComponentLogger("webhook", "listen", listen),
 	}
 
 	mux := http.NewServeMux()
@@ -60,6 +74,26 @@ func (w *WebhookServer) handleWebhook(rw http.ResponseWriter, req *http.Request)
 		return
 	}
 
+	client := w.acl.ClientIP(req)
+	if !w.acl.Allowed(client) {
+		http.Error(rw, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	limited := !w.limiter.Allow(client, authToken(req))
+	if limited {
+		switch w.limiter.Action() {
+		case RateLimitReject:
+			http.Error(rw, "Too many requests", http.StatusTooManyRequests)
+			return
+		case RateLimitDrop:
+			// Discarded silently: the client gets the usual answer
+			rw.WriteHeader(http.StatusOK)
+			rw.Write([]byte("Message received successfully"))
+			return
+		}
+	}
+
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
 		http.Error(rw, "Error reading request body", http.StatusBadRequest)
@@ -67,21 +101,98 @@ func (w *WebhookServer) handleWebhook(rw http.ResponseWriter, req *http.Request)
 	}
 	defer req.Body.Close()
 
-	// Validate JSON format using sonic
-	var jsonData interface{}
-	if err := sonic.Unmarshal(body, &jsonData); err != nil {
+	// Validate JSON format, keeping the parsed value for later stages
+	evt, err := ParseEvent(body)
+	if err != nil {
 		http.Error(rw, "Invalid JSON format", http.StatusBadRequest)
 		return
 	}
 
-	// Send the original message to the channel
-	w.msgChan <- body
+	if w.schema != nil {
+		if errs := w.schema.Validate(evt); len(errs) > 0 {
+			AddMetric("webhook.schema_errors", 1)
+			if w.quarantineTopic == "" {
+				http.Error(rw, strings.Join(errs, "\n"), http.StatusUnprocessableEntity)
+				return
+			}
+			evt.SetTopic(w.quarantineTopic)
+			evt.SetHeader("validation_errors", strings.Join(errs, "; "))
+			w.msgChan <- evt
+			rw.WriteHeader(http.StatusAccepted)
+			rw.Write([]byte("Message quarantined"))
+			return
+		}
+	}
+
+	if limited || w.remoteAddrField != nil {
+		if data, err := evt.Data(); err == nil {
+			if limited {
+				w.limiter.Tag(data)
+			}
+			if w.remoteAddrField != nil {
+				SetField(data, w.remoteAddrField, client)
+			}
+			evt.MarkDirty()
+		}
+	}
+
+	// Send the processed message to the channel
+	if err := sendEvent(w.pipeline, evt, w.msgChan, w.log); err != nil {
+		http.Error(rw, "Error processing message: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 
 	// Return success response
 	rw.WriteHeader(http.StatusOK)
 	rw.Write([]byte("Message received successfully"))
 }
 
+// SetPipeline sets the processors applied to every payload before it is
+// queued for Kafka.
+func (w *WebhookServer) SetPipeline(pipeline *Pipeline) {
+	w.pipeline = pipeline
+}
+
+// SetRateLimiter sets the limiter applied per listener, client address and
+// auth token.
+func (w *WebhookServer) SetRateLimiter(limiter *RateLimiter) {
+	w.limiter = limiter
+}
+
+// SetACL sets the IP access lists checked for every request.
+func (w *WebhookServer) SetACL(acl *ACL) {
+	w.acl = acl
+}
+
+// SetRemoteAddrField sets the field receiving the client address of every
+// JSON object payload. An empty field leaves payloads unchanged.
+func (w *WebhookServer) SetRemoteAddrField(field string) {
+	w.remoteAddrField = nil
+	if field != "" {
+		w.remoteAddrField = StringToList(field)
+	}
+}
+
+// SetSchema sets the JSON Schema every payload must match. Invalid payloads
+// are rejected with 422, or, when quarantineTopic is set, sent unprocessed to
+// that topic with the validation errors in the validation_errors header.
+func (w *WebhookServer) SetSchema(schema *JSONSchema, quarantineTopic string) {
+	w.schema = schema
+	w.quarantineTopic = quarantineTopic
+}
+
+// authToken returns the credentials of the Authorization header, without
+// the scheme, or the X-Api-Key header.
+func authToken(req *http.Request) string {
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		if i := strings.IndexByte(auth, ' '); i >= 0 {
+			return auth[i+1:]
+		}
+		return auth
+	}
+	return req.Header.Get("X-Api-Key")
+}
+
 func (w *WebhookServer) Run() error {
 	if strings.HasPrefix(w.listen, "https://") {
 		return w.server.ListenAndServeTLS(w.tls.CertFile, w.tls.KeyFile)
@@ -89,8 +200,17 @@ func (w *WebhookServer) Run() error {
 	return w.server.ListenAndServe()
 }
 
+// Stop closes the listener and waits up to webhookStopTimeout for the
+// running requests, so that their events are queued, before closing their
+// connections.
 func (w *WebhookServer) Stop() error {
-	return w.server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), webhookStopTimeout)
+	defer cancel()
+	if err := w.server.Shutdown(ctx); err != nil {
+		_ = w.server.Close()
+		return err
+	}
+	return nil
 }
 
 func (w *WebhookServer) ListenAddr() string {
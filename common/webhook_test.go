@@ -0,0 +1,171 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWebhook(t *testing.T) (*WebhookServer, chan *Event) {
+	t.Helper()
+	msgChan := make(chan *Event, 16)
+	w, err := NewWebhook("http://127.0.0.1:0", "/", msgChan, nil)
+	require.NoError(t, err)
+	return w, msgChan
+}
+
+// postWebhook sends body to w from remoteAddr and returns the response and
+// the events forwarded by it.
+func postWebhook(w *WebhookServer, msgChan chan *Event, remoteAddr, body string, header http.Header) (*httptest.ResponseRecorder, []*Event) {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.RemoteAddr = remoteAddr
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	w.handleWebhook(rec, req)
+
+	var events []*Event
+	for len(msgChan) > 0 {
+		events = append(events, <-msgChan)
+	}
+	return rec, events
+}
+
+func TestWebhookRateLimit(t *testing.T) {
+	tests := []struct {
+		action string
+		status int
+		tags   []interface{}
+	}{
+		// Dropped payloads are acknowledged but not forwarded
+		{RateLimitDrop, http.StatusOK, nil},
+		{RateLimitReject, http.StatusTooManyRequests, nil},
+		{RateLimitTag, http.StatusOK, []interface{}{"rate_limited"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			w, msgChan := newTestWebhook(t)
+			limiter, err := NewRateLimiter("http://127.0.0.1:0", &RateLimitConfig{
+				IP:     &RateConfig{PerSecond: 0.001, Burst: 1},
+				Action: tt.action,
+			})
+			require.NoError(t, err)
+			w.SetRateLimiter(limiter)
+
+			rec, events := postWebhook(w, msgChan, "192.0.2.1:1234", `{"n":1}`, nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			require.Len(t, events, 1)
+			data, err := events[0].Data()
+			require.NoError(t, err)
+			assert.NotContains(t, data, "tags")
+
+			rec, events = postWebhook(w, msgChan, "192.0.2.1:1234", `{"n":2}`, nil)
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.tags == nil {
+				assert.Empty(t, events)
+				return
+			}
+			require.Len(t, events, 1)
+			data, err = events[0].Data()
+			require.NoError(t, err)
+			assert.Equal(t, tt.tags, data["tags"])
+		})
+	}
+}
+
+func TestWebhookACL(t *testing.T) {
+	tests := []struct {
+		name   string
+		peer   string
+		header http.Header
+		status int
+	}{
+		{"allowed peer", "192.0.2.1:1234", nil, http.StatusOK},
+		{"denied peer", "203.0.113.1:1234", nil, http.StatusForbidden},
+		{"allowed client behind proxy", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"192.0.2.1"}}, http.StatusOK},
+		{"denied client behind proxy", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"203.0.113.1"}}, http.StatusForbidden},
+		// Only a trusted proxy may name the client
+		{"spoofed header", "203.0.113.1:1234", http.Header{"X-Forwarded-For": {"192.0.2.1"}}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, msgChan := newTestWebhook(t)
+			acl, err := NewACL("http://127.0.0.1:0", &ACLConfig{
+				Allow:          []string{"192.0.2.0/24", "10.0.0.0/8"},
+				TrustedProxies: []string{"10.0.0.0/8"},
+			})
+			require.NoError(t, err)
+			w.SetACL(acl)
+
+			rec, events := postWebhook(w, msgChan, tt.peer, `{"n":1}`, tt.header)
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.status == http.StatusOK {
+				assert.Len(t, events, 1)
+			} else {
+				assert.Empty(t, events)
+			}
+		})
+	}
+}
+
+func TestWebhookSchema(t *testing.T) {
+	schemaPath := filepath.Join(t.TempDir(), "schema.json")
+	require.NoError(t, os.WriteFile(schemaPath, []byte(`{
+		"type": "object",
+		"required": ["n"],
+		"properties": {"n": {"type": "integer"}}
+	}`), 0o600))
+	schema, err := NewJSONSchema(schemaPath)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		quarantine string
+		body       string
+		status     int
+		response   string
+		topic      string
+		errors     string
+	}{
+		{name: "valid", body: `{"n":1}`, status: http.StatusOK},
+		{name: "valid with quarantine", quarantine: "bad", body: `{"n":1}`, status: http.StatusOK},
+		{name: "invalid", body: `{"n":"x"}`, status: http.StatusUnprocessableEntity, response: "/n: "},
+		{name: "missing field", body: `{}`, status: http.StatusUnprocessableEntity, response: "missing properties: 'n'"},
+		{name: "quarantined", quarantine: "bad", body: `{"n":"x"}`, status: http.StatusAccepted, topic: "bad", errors: "/n: "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, msgChan := newTestWebhook(t)
+			w.SetSchema(schema, tt.quarantine)
+
+			rec, events := postWebhook(w, msgChan, "192.0.2.1:1234", tt.body, nil)
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.status == http.StatusUnprocessableEntity {
+				assert.Contains(t, rec.Body.String(), tt.response)
+				assert.Empty(t, events)
+				return
+			}
+
+			require.Len(t, events, 1)
+			assert.Equal(t, tt.topic, events[0].Topic())
+			if tt.errors == "" {
+				assert.Empty(t, events[0].headers)
+				return
+			}
+			assert.Contains(t, events[0].headers["validation_errors"], tt.errors)
+			raw, err := events[0].Bytes()
+			require.NoError(t, err)
+			assert.Equal(t, tt.body, string(raw))
+		})
+	}
+}
@@ -1,29 +1,78 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"syslog_webhook_to_kafka/common"
 
 	"gopkg.in/yaml.v3"
 )
 
+// topicCheckTimeout bounds the startup check of the topics of a Kafka
+// instance
+const topicCheckTimeout = 30 * time.Second
+
 type KafkaConfig struct {
 	ID      string   `yaml:"id"`
 	Brokers []string `yaml:"brokers"`
 	Topic   string   `yaml:"topic"`
 	Key     string   `yaml:"key,omitempty"`
+
+	KeyFields    []string `yaml:"key_fields,omitempty"`
+	KeySeparator string   `yaml:"key_separator,omitempty"`
+	KeyHash      string   `yaml:"key_hash,omitempty"`
+	KeyDefault   string   `yaml:"key_default,omitempty"`
+
+	Processors []common.ProcessorConfig `yaml:"processors,omitempty"`
+	Queue      *common.QueueConfig      `yaml:"queue,omitempty"`
+	Encoding   *common.EncoderConfig    `yaml:"encoding,omitempty"`
+	ErrorTopic string                   `yaml:"error_topic,omitempty"`
+	// CreateTopic creates missing topics at startup instead of failing
+	CreateTopic *common.TopicConfig `yaml:"create_topic,omitempty"`
+}
+
+// Route selects the Kafka instances or sinks a source sends its events to
+type Route struct {
+	Destination string `yaml:"destination,omitempty"`
+	// Destinations mirrors every event to several destinations
+	Destinations []string `yaml:"destinations,omitempty"`
+	// KafkaID and KafkaIDs are the former names of Destination and
+	// Destinations
+	KafkaID  string   `yaml:"kafka_id,omitempty"`
+	KafkaIDs []string `yaml:"kafka_ids,omitempty"`
+}
+
+// ids returns the ids of the destinations of a validated route.
+func (r *Route) ids() []string {
+	if r.Destination != "" {
+		return []string{r.Destination}
+	}
+	return r.Destinations
 }
 
 type SyslogServerConfig struct {
 	Listen   string `yaml:"listen"`
 	Format   string `yaml:"format"`
 	Protocol string `yaml:"protocol"`
-	KafkaID  string `yaml:"kafka_id"`
+	Route    `yaml:",inline"`
+	KeepRaw  bool `yaml:"keep_raw,omitempty"`
+
+	Parsers    []common.ContentParserConfig `yaml:"parsers,omitempty"`
+	Processors []common.ProcessorConfig     `yaml:"processors,omitempty"`
+	RateLimit  *common.RateLimitConfig      `yaml:"rate_limit,omitempty"`
+	ACL        *common.ACLConfig            `yaml:"acl,omitempty"`
+	Multiline  *common.MultilineConfig      `yaml:"multiline,omitempty"`
+	TLS        *common.ServerTLSConfig      `yaml:"tls,omitempty"`
 	//Grok     string `yaml:"grok"`
 	//
 	//NamedCapturesOnly   bool `yaml:"named_captures_only,omitempty"`
@@ -38,33 +87,88 @@ type WebhookTLSConfig struct {
 }
 
 type WebhookConfig struct {
-	Listen  string           `yaml:"listen"`
-	Path    string           `yaml:"path"`
-	TLS     WebhookTLSConfig `yaml:"tls,omitempty"`
-	KafkaID string           `yaml:"kafka_id"`
+	Listen string           `yaml:"listen"`
+	Path   string           `yaml:"path"`
+	TLS    WebhookTLSConfig `yaml:"tls,omitempty"`
+	Route  `yaml:",inline"`
+	// RemoteAddrField receives the client address of each payload
+	RemoteAddrField string `yaml:"remote_addr_field,omitempty"`
+	// Schema is a JSON Schema file payloads are validated against
+	Schema          string `yaml:"schema,omitempty"`
+	QuarantineTopic string `yaml:"quarantine_topic,omitempty"`
+
+	Processors []common.ProcessorConfig `yaml:"processors,omitempty"`
+	RateLimit  *common.RateLimitConfig  `yaml:"rate_limit,omitempty"`
+	ACL        *common.ACLConfig        `yaml:"acl,omitempty"`
+}
+
+type AdminConfig struct {
+	Listen string `yaml:"listen"`
+	// Token authorizes changes such as the log level. Without it only
+	// loopback clients may change state.
+	Token string `yaml:"token,omitempty"`
+}
+
+// SinkConfig represents a non-Kafka destination. Sources refer to it by id
+// through destination like to a Kafka instance.
+type SinkConfig struct {
+	ID                string `yaml:"id"`
+	common.SinkConfig `yaml:",inline"`
+
+	Processors []common.ProcessorConfig `yaml:"processors,omitempty"`
+	Queue      *common.QueueConfig      `yaml:"queue,omitempty"`
 }
 
 type Config struct {
 	Kafka   []KafkaConfig        `yaml:"kafka"`
+	Sinks   []SinkConfig         `yaml:"sinks,omitempty"`
 	Syslog  []SyslogServerConfig `yaml:"syslog"`
 	Webhook []WebhookConfig      `yaml:"webhook"`
+	Admin   AdminConfig          `yaml:"admin,omitempty"`
+	Log     common.LogConfig     `yaml:"log,omitempty"`
 }
 
 func validateConfig(config *Config) error {
 	// Validate Kafka configurations
-	if len(config.Kafka) == 0 {
-		return fmt.Errorf("kafka configuration is required")
+	if len(config.Kafka) == 0 && len(config.Sinks) == 0 {
+		return fmt.Errorf("kafka or sinks configuration is required")
 	}
+	destinations := make(map[string]bool)
+	kafkaIDs := make(map[string]bool)
 	for i, k := range config.Kafka {
 		if k.ID == "" {
 			return fmt.Errorf("kafka[%d]: id is required", i)
 		}
+		if destinations[k.ID] {
+			return fmt.Errorf("kafka[%d]: duplicate id '%s'", i, k.ID)
+		}
+		destinations[k.ID] = true
+		kafkaIDs[k.ID] = true
 		if len(k.Brokers) == 0 {
 			return fmt.Errorf("kafka[%d]: at least one broker is required", i)
 		}
 		if k.Topic == "" {
 			return fmt.Errorf("kafka[%d]: topic is required", i)
 		}
+		if k.Key != "" && len(k.KeyFields) > 0 {
+			return fmt.Errorf("kafka[%d]: key and key_fields are mutually exclusive", i)
+		}
+	}
+
+	// Validate sink configurations
+	for i, sk := range config.Sinks {
+		if sk.ID == "" {
+			return fmt.Errorf("sinks[%d]: id is required", i)
+		}
+		if destinations[sk.ID] {
+			return fmt.Errorf("sinks[%d]: duplicate id '%s'", i, sk.ID)
+		}
+		destinations[sk.ID] = true
+		switch sk.Type {
+		case common.SinkFile, common.SinkStdout, common.SinkHTTP, common.SinkSyslog:
+		default:
+			return fmt.Errorf("sinks[%d]: unsupported type '%s'", i, sk.Type)
+		}
 	}
 
 	// Validate Syslog configurations
@@ -78,23 +182,21 @@ func validateConfig(config *Config) error {
 		if s.Protocol == "" {
 			return fmt.Errorf("syslog[%d]: protocol is required", i)
 		}
-		if s.KafkaID == "" {
-			return fmt.Errorf("syslog[%d]: kafka_id is required", i)
+		if err := validateRoute(&config.Syslog[i].Route, destinations); err != nil {
+			return fmt.Errorf("syslog[%d]: %w", i, err)
+		}
+		if s.ACL != nil && s.Protocol == "unixgram" {
+			return fmt.Errorf("syslog[%d]: acl is not supported for unixgram", i)
+		}
+		if s.Multiline != nil && s.Protocol != "tcp" && s.Protocol != "tls" {
+			return fmt.Errorf("syslog[%d]: multiline is only supported for tcp and tls", i)
+		}
+		if (s.Protocol == "tls") != (s.TLS != nil) {
+			return fmt.Errorf("syslog[%d]: tls settings are required for, and only allowed with, the tls protocol", i)
 		}
 		//if s.Grok == "" {
 		//	return fmt.Errorf("syslog[%d]: grok is required", i)
 		//}
-		// Validate that kafka_id exists in kafka configs
-		found := false
-		for _, k := range config.Kafka {
-			if k.ID == s.KafkaID {
-				found = true
-				break
-			}
-		}
-		if !found {
-			return fmt.Errorf("syslog[%d]: kafka_id '%s' not found in kafka configurations", i, s.KafkaID)
-		}
 	}
 
 	// Validate Webhook configurations
@@ -105,19 +207,19 @@ func validateConfig(config *Config) error {
 		if w.Path == "" {
 			return fmt.Errorf("webhook[%d]: path is required", i)
 		}
-		if w.KafkaID == "" {
-			return fmt.Errorf("webhook[%d]: kafka_id is required", i)
+		if err := validateRoute(&config.Webhook[i].Route, destinations); err != nil {
+			return fmt.Errorf("webhook[%d]: %w", i, err)
 		}
-		// Validate that kafka_id exists in kafka configs
-		found := false
-		for _, k := range config.Kafka {
-			if k.ID == w.KafkaID {
-				found = true
-				break
+		if w.QuarantineTopic != "" {
+			if w.Schema == "" {
+				return fmt.Errorf("webhook[%d]: quarantine_topic requires schema", i)
+			}
+			// Only Kafka destinations can write to another topic
+			for _, id := range config.Webhook[i].ids() {
+				if !kafkaIDs[id] {
+					return fmt.Errorf("webhook[%d]: quarantine_topic requires kafka destinations, '%s' is a sink", i, id)
+				}
 			}
-		}
-		if !found {
-			return fmt.Errorf("webhook[%d]: kafka_id '%s' not found in kafka configurations", i, w.KafkaID)
 		}
 		// Validate TLS configuration for HTTPS
 		if strings.HasPrefix(w.Listen, "https://") {
@@ -130,6 +232,73 @@ func validateConfig(config *Config) error {
 		}
 	}
 
+	// A blocking queue would hold back the other destinations of its
+	// sources
+	teed := teeDestinations(config)
+	for i, k := range config.Kafka {
+		if teed[k.ID] && k.Queue != nil && k.Queue.Overflow == common.OverflowBlock {
+			return fmt.Errorf("kafka[%d]: overflow block is not supported for a destination of a source with several destinations", i)
+		}
+	}
+	for i, sk := range config.Sinks {
+		if teed[sk.ID] && sk.Queue != nil && sk.Queue.Overflow == common.OverflowBlock {
+			return fmt.Errorf("sinks[%d]: overflow block is not supported for a destination of a source with several destinations", i)
+		}
+	}
+
+	return nil
+}
+
+// teeDestinations returns the ids of the destinations shared through a tee,
+// i.e. of validated routes with several destinations.
+func teeDestinations(config *Config) map[string]bool {
+	var routes []Route
+	for _, sc := range config.Syslog {
+		routes = append(routes, sc.Route)
+	}
+	for _, wc := range config.Webhook {
+		routes = append(routes, wc.Route)
+	}
+	res := make(map[string]bool)
+	for _, r := range routes {
+		if ids := r.ids(); len(ids) > 1 {
+			for _, id := range ids {
+				res[id] = true
+			}
+		}
+	}
+	return res
+}
+
+// validateRoute moves the former kafka_id and kafka_ids settings to
+// destination and destinations, then checks that exactly one of them is set
+// and that every id refers to a Kafka instance or sink.
+func validateRoute(r *Route, destinations map[string]bool) error {
+	if r.KafkaID != "" || len(r.KafkaIDs) > 0 {
+		if r.Destination != "" || len(r.Destinations) > 0 {
+			return fmt.Errorf("kafka_id and kafka_ids cannot be combined with destination and destinations")
+		}
+		r.Destination, r.Destinations = r.KafkaID, r.KafkaIDs
+		r.KafkaID, r.KafkaIDs = "", nil
+	}
+
+	if r.Destination == "" && len(r.Destinations) == 0 {
+		return fmt.Errorf("destination or destinations is required")
+	}
+	if r.Destination != "" && len(r.Destinations) > 0 {
+		return fmt.Errorf("destination and destinations are mutually exclusive")
+	}
+	ids := r.ids()
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if !destinations[id] {
+			return fmt.Errorf("destination '%s' not found in kafka or sink configurations", id)
+		}
+		if seen[id] {
+			return fmt.Errorf("duplicate destination '%s'", id)
+		}
+		seen[id] = true
+	}
 	return nil
 }
 
@@ -137,66 +306,163 @@ func main() {
 	// Read config file
 	data, err := os.ReadFile("config.yaml")
 	if err != nil {
-		fmt.Printf("Error reading config file: %v\n", err)
-		os.Exit(1)
+		fatal(slog.Default(), "Error reading config file", "error", err)
 	}
 
 	// Parse YAML
 	var config Config
 	if err := yaml.Unmarshal(data, &config); err != nil {
-		fmt.Printf("Error parsing YAML: %v\n", err)
-		os.Exit(1)
+		fatal(slog.Default(), "Error parsing YAML", "error", err)
+	}
+
+	// Set up logging before any component creates its logger
+	if err := common.SetupLogging(config.Log, os.Stdout); err != nil {
+		fatal(slog.Default(), "Error setting up logging", "error", err)
 	}
+	logger := slog.Default()
 
 	// Validate configuration
 	if err := validateConfig(&config); err != nil {
-		fmt.Printf("Configuration validation failed: %v\n", err)
-		os.Exit(1)
+		fatal(logger, "Configuration validation failed", "error", err)
 	}
 
-	// Create message channels for each Kafka instance
-	msgChans := make(map[string]chan []byte)
-	for _, kc := range config.Kafka {
-		msgChans[kc.ID] = make(chan []byte, 100)
+	// Create a queue for each destination
+	queues := make(map[string]*common.Queue)
+	msgChans := make(map[string]chan *common.Event)
+	for id, cfg := range destinationQueues(&config) {
+		queue, err := common.NewQueue(id, cfg)
+		if err != nil {
+			fatal(logger, "Error creating queue", "destination", id, "error", err)
+		}
+		queues[id] = queue
+		msgChans[id] = queue.In()
 	}
 
 	// Initialize Kafka producers
-	kafkaProducers := make(map[string]*common.KafkaProducer)
+	sinks := make(map[string]common.Sink)
+	var pipelines []*common.Pipeline
 	for _, kc := range config.Kafka {
-		var keyField = []string{}
+		keyConfig := &common.KafkaKeyConfig{
+			Fields:    kc.KeyFields,
+			Separator: kc.KeySeparator,
+			Hash:      kc.KeyHash,
+			Default:   kc.KeyDefault,
+		}
 		if kc.Key != "" {
-			keyField = common.StringToList(kc.Key)
+			keyConfig.Fields = []string{kc.Key}
+		}
+		if keyConfig.Separator == "" {
+			keyConfig.Separator = "|"
 		}
 
-		producer, err := common.NewKafkaProducer(kc.Brokers, kc.Topic, keyField)
+		kafkaLogger := common.ComponentLogger("kafka", "kafka_id", kc.ID)
+		producer, err := common.NewKafkaProducer(kc.Brokers, kc.Topic, keyConfig)
 		if err != nil {
-			fmt.Printf("Error creating Kafka producer: %v\n", err)
-			os.Exit(1)
-		}
-		kafkaProducers[kc.ID] = producer
-		fmt.Printf("[INFO] Kafka producer initialized: id=%s, topic=%s\n", kc.ID, kc.Topic)
-
-		// Start message consumer for this Kafka instance
-		go func(kafkaID string, msgChan chan []byte) {
-			fmt.Printf("[INFO] Starting message consumer for Kafka: %s\n", kafkaID)
-			for msg := range msgChan {
-				if err := kafkaProducers[kafkaID].SendMessage(msg); err != nil {
-					fmt.Printf("Error sending message to Kafka %s: %v\n", kafkaID, err)
-				}
-			}
-		}(kc.ID, msgChans[kc.ID])
+			fatal(kafkaLogger, "Error creating Kafka producer", "error", err)
+		}
+		encoder, err := common.NewEncoder(kc.Encoding, kc.Topic)
+		if err != nil {
+			fatal(kafkaLogger, "Error creating Kafka encoder", "error", err)
+		}
+		if kc.Encoding != nil && kc.Encoding.Type != "" {
+			producer.SetEncoder(kc.Encoding.Type, encoder)
+		} else {
+			producer.SetEncoder(common.EncodingJSON, encoder)
+		}
+		producer.SetErrorTopic(kc.ErrorTopic)
+		sinks[kc.ID] = producer
+
+		ctx, cancel := context.WithTimeout(context.Background(), topicCheckTimeout)
+		err = producer.EnsureTopics(ctx, kc.CreateTopic, kafkaTopics(&config, kc)...)
+		cancel()
+		if err != nil {
+			fatal(kafkaLogger, "Kafka topic check failed", "error", err)
+		}
+		kafkaLogger.Info("Kafka producer initialized", "topic", kc.Topic)
+
+		pipeline, err := common.NewPipeline(kc.Processors)
+		if err != nil {
+			fatal(kafkaLogger, "Error creating Kafka processors", "error", err)
+		}
+		pipelines = append(pipelines, pipeline)
+
+		// Start the workers for this Kafka instance
+		startWorkers(queues[kc.ID], pipeline, producer, kafkaLogger)
+	}
+
+	// Initialize other sinks
+	for _, sc := range config.Sinks {
+		sinkLogger := common.ComponentLogger("sink", "sink_id", sc.ID)
+		sink, err := common.NewSink(sc.ID, &sc.SinkConfig)
+		if err != nil {
+			fatal(sinkLogger, "Error creating sink", "error", err)
+		}
+		sinks[sc.ID] = sink
+		sinkLogger.Info("Sink initialized", "type", sc.Type)
+
+		pipeline, err := common.NewPipeline(sc.Processors)
+		if err != nil {
+			fatal(sinkLogger, "Error creating sink processors", "error", err)
+		}
+		pipelines = append(pipelines, pipeline)
+		startWorkers(queues[sc.ID], pipeline, sink, sinkLogger)
 	}
 
+	// Sources with several destinations send through tees
+	var tees teeGroup
+
 	// Initialize syslog servers
 	var syslogServers []*common.SyslogConfig
 	for _, sc := range config.Syslog {
-		server, err := common.NewSyslog(sc.Listen, sc.Protocol, sc.Format, msgChans[sc.KafkaID])
+		syslogLogger := common.ComponentLogger("syslog", "listen", sc.Listen)
+		server, err := common.NewSyslog(sc.Listen, sc.Protocol, sc.Format, tees.sourceChan(sc.Destination, sc.Destinations, msgChans, syslogLogger))
 		if err != nil {
-			fmt.Printf("Error creating syslog server: %v\n", err)
-			os.Exit(1)
+			fatal(syslogLogger, "Error creating syslog server", "error", err)
+		}
+		server.SetKeepRaw(sc.KeepRaw)
+
+		if sc.TLS != nil {
+			if err := server.SetTLS(sc.TLS); err != nil {
+				fatal(syslogLogger, "Error setting up syslog tls", "error", err)
+			}
+		}
+
+		parsers, err := common.NewContentParsers(sc.Parsers)
+		if err != nil {
+			fatal(syslogLogger, "Error creating syslog content parsers", "error", err)
+		}
+		server.SetContentParsers(parsers)
+
+		pipeline, err := common.NewPipeline(sc.Processors)
+		if err != nil {
+			fatal(syslogLogger, "Error creating syslog processors", "error", err)
+		}
+		pipelines = append(pipelines, pipeline)
+		server.SetPipeline(pipeline)
+
+		if sc.RateLimit != nil {
+			limiter, err := common.NewRateLimiter(sc.Listen, sc.RateLimit)
+			if err != nil {
+				fatal(syslogLogger, "Error creating syslog rate limiter", "error", err)
+			}
+			server.SetRateLimiter(limiter)
+		}
+
+		if sc.ACL != nil {
+			acl, err := common.NewACL(sc.Listen, sc.ACL)
+			if err != nil {
+				fatal(syslogLogger, "Error creating syslog acl", "error", err)
+			}
+			server.SetACL(acl)
+		}
+
+		if sc.Multiline != nil {
+			if err := server.SetMultiline(sc.Multiline); err != nil {
+				fatal(syslogLogger, "Error creating syslog multiline aggregator", "error", err)
+			}
 		}
 		syslogServers = append(syslogServers, server)
-		fmt.Printf("[INFO] Starting syslog server: listen=%s, protocol=%s\n", sc.Listen, sc.Protocol)
+		syslogLogger.Info("Starting syslog server", "protocol", sc.Protocol)
 		go server.Run()
 	}
 
@@ -211,26 +477,70 @@ func main() {
 				KeyFile:  wc.TLS.KeyFile,
 			}
 		}
-		server, err := common.NewWebhook(wc.Listen, wc.Path, msgChans[wc.KafkaID], tlsConfig)
+		webhookLogger := common.ComponentLogger("webhook", "listen", wc.Listen)
+		server, err := common.NewWebhook(wc.Listen, wc.Path, tees.sourceChan(wc.Destination, wc.Destinations, msgChans, webhookLogger), tlsConfig)
+		if err != nil {
+			fatal(webhookLogger, "Error creating webhook server", "error", err)
+		}
+		pipeline, err := common.NewPipeline(wc.Processors)
 		if err != nil {
-			fmt.Printf("Error creating webhook server: %v\n", err)
-			os.Exit(1)
+			fatal(webhookLogger, "Error creating webhook processors", "error", err)
+		}
+		pipelines = append(pipelines, pipeline)
+		server.SetPipeline(pipeline)
+		server.SetRemoteAddrField(wc.RemoteAddrField)
+
+		if wc.Schema != "" {
+			schema, err := common.NewJSONSchema(wc.Schema)
+			if err != nil {
+				fatal(webhookLogger, "Error loading webhook schema", "error", err)
+			}
+			server.SetSchema(schema, wc.QuarantineTopic)
+		}
+
+		if wc.RateLimit != nil {
+			limiter, err := common.NewRateLimiter(wc.Listen, wc.RateLimit)
+			if err != nil {
+				fatal(webhookLogger, "Error creating webhook rate limiter", "error", err)
+			}
+			server.SetRateLimiter(limiter)
+		}
+
+		if wc.ACL != nil {
+			acl, err := common.NewACL(wc.Listen, wc.ACL)
+			if err != nil {
+				fatal(webhookLogger, "Error creating webhook acl", "error", err)
+			}
+			server.SetACL(acl)
 		}
 		webhookServers = append(webhookServers, server)
-		fmt.Printf("[INFO] Starting webhook server: listen=%s, path=%s\n", wc.Listen, wc.Path)
+		webhookLogger.Info("Starting webhook server", "path", wc.Path)
 	}
 
 	// Start webhook servers
 	for _, server := range webhookServers {
 		go func(s *common.WebhookServer) {
-			if err := s.Run(); err != nil {
-				fmt.Printf("Webhook server error: %v\n", err)
-				os.Exit(1)
+			if err := s.Run(); err != nil && err != http.ErrServerClosed {
+				fatal(logger, "Webhook server error", "listen", s.ListenAddr(), "error", err)
 			}
 		}(server)
 	}
 
-	fmt.Println("[INFO] All servers started successfully")
+	// Start admin server
+	var adminServer *common.AdminServer
+	if config.Admin.Listen != "" {
+		adminServer = common.NewAdmin(config.Admin.Listen)
+		adminServer.SetToken(config.Admin.Token)
+		adminServer.HandleProtected("/log/level", common.LogLevelHandler())
+		logger.Info("Starting admin server", "listen", config.Admin.Listen)
+		go func() {
+			if err := adminServer.Run(); err != nil {
+				fatal(logger, "Admin server error", "error", err)
+			}
+		}()
+	}
+
+	logger.Info("All servers started successfully")
 
 	// Handle graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -238,12 +548,19 @@ func main() {
 
 	// Wait for shutdown signal
 	<-sigChan
-	fmt.Println("\n[INFO] Received shutdown signal, stopping servers...")
+	logger.Info("Received shutdown signal, stopping servers")
+
+	// Stop admin server
+	if adminServer != nil {
+		if err := adminServer.Stop(); err != nil {
+			logger.Error("Error stopping admin server", "error", err)
+		}
+	}
 
 	// Stop all webhook servers
 	for _, server := range webhookServers {
 		if err := server.Stop(); err != nil {
-			fmt.Printf("Error stopping webhook server: %v\n", err)
+			logger.Error("Error stopping webhook server", "listen", server.ListenAddr(), "error", err)
 		}
 	}
 
@@ -252,12 +569,144 @@ func main() {
 		server.Stop()
 	}
 
-	// Close all Kafka producers
-	for _, producer := range kafkaProducers {
-		if err := producer.Close(); err != nil {
-			fmt.Printf("Error closing Kafka producer: %v\n", err)
+	// Copy the events the sources left in the tee inputs to the queues
+	tees.Close()
+
+	// Stop the workers so that nothing is sent to a closed sink, then close
+	// the spill files
+	for id, queue := range queues {
+		queue.Stop()
+		if err := queue.Close(); err != nil {
+			logger.Error("Error closing queue", "destination", id, "error", err)
 		}
 	}
 
-	fmt.Println("[INFO] All servers stopped successfully")
+	// Close what the processors hold open, now that nothing runs them
+	for _, pipeline := range pipelines {
+		if err := pipeline.Close(); err != nil {
+			logger.Error("Error closing processors", "error", err)
+		}
+	}
+
+	// Close all sinks
+	for id, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			logger.Error("Error closing sink", "id", id, "error", err)
+		}
+	}
+
+	logger.Info("All servers stopped successfully")
+}
+
+// teeGroup runs the goroutines copying the events of sources with several
+// destinations to each destination queue.
+type teeGroup struct {
+	ins []chan *common.Event
+	wg  sync.WaitGroup
+}
+
+// sourceChan returns the channel a source sends its events to: the queue of
+// its destination, or with destinations a channel whose events are copied to
+// each destination queue.
+func (g *teeGroup) sourceChan(id string, ids []string, msgChans map[string]chan *common.Event, log *slog.Logger) chan *common.Event {
+	if id != "" {
+		return msgChans[id]
+	}
+	if len(ids) == 1 {
+		return msgChans[ids[0]]
+	}
+	outs := make(map[string]chan *common.Event, len(ids))
+	for _, id := range ids {
+		outs[id] = msgChans[id]
+	}
+	in := make(chan *common.Event, 100)
+	g.ins = append(g.ins, in)
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		common.Tee(in, outs, log)
+	}()
+	return in
+}
+
+// Close closes the tee inputs, which the sources must no longer send to,
+// and waits until their events are queued.
+func (g *teeGroup) Close() {
+	for _, in := range g.ins {
+		close(in)
+	}
+	g.wg.Wait()
+}
+
+// kafkaTopics returns the topics written through kc: its topic, its error
+// topic and the quarantine topics of webhooks sending to it.
+func kafkaTopics(config *Config, kc KafkaConfig) []string {
+	topics := []string{kc.Topic}
+	seen := map[string]bool{kc.Topic: true}
+	add := func(topic string) {
+		if topic != "" && !seen[topic] {
+			seen[topic] = true
+			topics = append(topics, topic)
+		}
+	}
+	add(kc.ErrorTopic)
+	for _, wc := range config.Webhook {
+		if wc.Destination == kc.ID || slices.Contains(wc.Destinations, kc.ID) {
+			add(wc.QuarantineTopic)
+		}
+	}
+	return topics
+}
+
+// destinationQueues returns the queue configuration of every destination.
+// Destinations shared through a tee drop new events instead of blocking
+// unless they set another overflow policy.
+func destinationQueues(config *Config) map[string]*common.QueueConfig {
+	res := make(map[string]*common.QueueConfig)
+	for _, kc := range config.Kafka {
+		res[kc.ID] = kc.Queue
+	}
+	for _, sc := range config.Sinks {
+		res[sc.ID] = sc.Queue
+	}
+	for id := range teeDestinations(config) {
+		if cfg := res[id]; cfg == nil || cfg.Overflow == "" {
+			c := common.QueueConfig{}
+			if cfg != nil {
+				c = *cfg
+			}
+			c.Overflow = common.OverflowDropNewest
+			res[id] = &c
+		}
+	}
+	return res
+}
+
+// startWorkers runs the destination processors on the events of queue and
+// sends them to sink. Quarantined events are sent as received.
+func startWorkers(queue *common.Queue, pipeline *common.Pipeline, sink common.Sink, log *slog.Logger) {
+	log.Info("Starting message consumer")
+	queue.Run(func(msg *common.Event) {
+		if msg.Topic() != "" {
+			if err := sink.SendMessage(msg); err != nil {
+				log.Error("Error sending message", "error", err)
+			}
+			return
+		}
+		events, err := pipeline.Process(msg)
+		if err != nil {
+			log.Error("Error processing message, dropping it", "error", err)
+		}
+		for _, evt := range events {
+			if err := sink.SendMessage(evt); err != nil {
+				log.Error("Error sending message", "error", err)
+			}
+		}
+	})
+}
+
+// fatal logs msg at error level and exits.
+func fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+
+	"syslog_webhook_to_kafka/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRoute(t *testing.T) {
+	destinations := map[string]bool{"kafka1": true, "kafka2": true}
+	tests := []struct {
+		name  string
+		route Route
+		want  Route
+		err   string
+	}{
+		{name: "destination", route: Route{Destination: "kafka1"}, want: Route{Destination: "kafka1"}},
+		{name: "destinations", route: Route{Destinations: []string{"kafka1", "kafka2"}}, want: Route{Destinations: []string{"kafka1", "kafka2"}}},
+		{name: "former kafka_id", route: Route{KafkaID: "kafka1"}, want: Route{Destination: "kafka1"}},
+		{name: "former kafka_ids", route: Route{KafkaIDs: []string{"kafka2"}}, want: Route{Destinations: []string{"kafka2"}}},
+		{name: "missing", err: "destination or destinations is required"},
+		{name: "both", route: Route{Destination: "kafka1", Destinations: []string{"kafka2"}}, err: "mutually exclusive"},
+		{name: "old and new names", route: Route{Destination: "kafka1", KafkaID: "kafka1"}, err: "cannot be combined"},
+		{name: "unknown", route: Route{Destination: "kafka3"}, err: "destination 'kafka3' not found"},
+		{name: "duplicate", route: Route{Destinations: []string{"kafka1", "kafka1"}}, err: "duplicate destination 'kafka1'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRoute(&tt.route, destinations)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, tt.route)
+		})
+	}
+}
+
+func TestValidateConfigQuarantineTopic(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook WebhookConfig
+		err     string
+	}{
+		{name: "kafka", webhook: WebhookConfig{Route: Route{Destination: "kafka1"}, Schema: "s.json", QuarantineTopic: "bad"}},
+		{name: "former kafka_id", webhook: WebhookConfig{Route: Route{KafkaID: "kafka1"}, Schema: "s.json", QuarantineTopic: "bad"}},
+		{name: "sink without quarantine", webhook: WebhookConfig{Route: Route{Destination: "file1"}, Schema: "s.json"}},
+		{name: "without schema", webhook: WebhookConfig{Route: Route{Destination: "kafka1"}, QuarantineTopic: "bad"}, err: "quarantine_topic requires schema"},
+		{name: "sink", webhook: WebhookConfig{Route: Route{Destination: "file1"}, Schema: "s.json", QuarantineTopic: "bad"}, err: "'file1' is a sink"},
+		{name: "kafka and sink", webhook: WebhookConfig{Route: Route{Destinations: []string{"kafka1", "file1"}}, Schema: "s.json", QuarantineTopic: "bad"}, err: "'file1' is a sink"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.webhook.Listen, tt.webhook.Path = "http://127.0.0.1:8080", "/"
+			config := &Config{
+				Kafka:   []KafkaConfig{{ID: "kafka1", Brokers: []string{"127.0.0.1:9092"}, Topic: "logs"}},
+				Sinks:   []SinkConfig{{ID: "file1", SinkConfig: common.SinkConfig{Type: common.SinkFile}}},
+				Webhook: []WebhookConfig{tt.webhook},
+			}
+			err := validateConfig(config)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestTeeDestinationOverflow(t *testing.T) {
+	block := &common.QueueConfig{Overflow: common.OverflowBlock}
+	tests := []struct {
+		name   string
+		queue  *common.QueueConfig
+		route  Route
+		err    string
+		policy string
+	}{
+		{name: "tee default", route: Route{Destinations: []string{"kafka1", "file1"}}, policy: common.OverflowDropNewest},
+		{name: "tee keeps size", queue: &common.QueueConfig{Size: 5}, route: Route{Destinations: []string{"kafka1", "file1"}}, policy: common.OverflowDropNewest},
+		{name: "tee spill", queue: &common.QueueConfig{Overflow: common.OverflowSpill, SpillDir: "/tmp"}, route: Route{Destinations: []string{"kafka1", "file1"}}, policy: common.OverflowSpill},
+		{name: "tee block", queue: block, route: Route{Destinations: []string{"kafka1", "file1"}}, err: "kafka[0]: overflow block is not supported"},
+		{name: "single destination block", queue: block, route: Route{Destination: "kafka1"}, policy: common.OverflowBlock},
+		{name: "single destination default", route: Route{Destinations: []string{"kafka1"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				Kafka:  []KafkaConfig{{ID: "kafka1", Brokers: []string{"127.0.0.1:9092"}, Topic: "logs", Queue: tt.queue}},
+				Sinks:  []SinkConfig{{ID: "file1", SinkConfig: common.SinkConfig{Type: common.SinkFile}}},
+				Syslog: []SyslogServerConfig{{Listen: "127.0.0.1:514", Format: "RFC5424", Protocol: "udp", Route: tt.route}},
+			}
+			err := validateConfig(config)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+
+			var before common.QueueConfig
+			if tt.queue != nil {
+				before = *tt.queue
+			}
+			queues := destinationQueues(config)
+			var policy string
+			if queues["kafka1"] != nil {
+				policy = queues["kafka1"].Overflow
+			}
+			assert.Equal(t, tt.policy, policy)
+			if tt.queue != nil {
+				assert.Equal(t, tt.queue.Size, queues["kafka1"].Size)
+				// The configuration of the destination is left unchanged
+				assert.Equal(t, before, *tt.queue)
+			}
+		})
+	}
+}
+
+func TestTeeGroupClose(t *testing.T) {
+	msgChans := map[string]chan *common.Event{
+		"a": make(chan *common.Event, 10),
+		"b": make(chan *common.Event, 10),
+	}
+	var tees teeGroup
+	assert.Equal(t, msgChans["a"], tees.sourceChan("a", nil, msgChans, common.ComponentLogger("test")))
+	in := tees.sourceChan("", []string{"a", "b"}, msgChans, common.ComponentLogger("test"))
+	for i := 0; i < 5; i++ {
+		in <- common.NewEventFromMap(map[string]interface{}{"n": i})
+	}
+
+	// Events still in the tee input are queued before Close returns
+	tees.Close()
+	assert.Len(t, msgChans["a"], 5)
+	assert.Len(t, msgChans["b"], 5)
+}